@@ -2,61 +2,40 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/process"
-)
-
-// Structure pour les informations CPU détaillées
-type CPUInfo struct {
-	VendorID   string `json:"vendor"`
-	Family     string `json:"family"`
-	Model      string `json:"model"`
-	MHz        string `json:"mhz"`
-	CacheSize  string `json:"cache_size"`
-	UserAgent  string `json:"user_agent"`
-}
-
-// Structure pour les données de performance par cœur
-type CPUClientCoreData struct {
-	Core       int     `json:"core"`
-	UserAgent  string  `json:"user_agent"`
-	CPUPercent float64 `json:"cpu_percent"`
-	Timestamp  string  `json:"timestamp"`
-}
 
-// Structure pour les informations de processus
-type ProcessInfo struct {
-	PID         int32   `json:"pid"`
-	Name        string  `json:"name"`
-	CPUPercent  float64 `json:"cpu_percent"`
-	MemPercent  float32 `json:"memory_percent"`
-	Status      string  `json:"status"`
-	Username    string  `json:"username"`
-	CreateTime  int64   `json:"create_time"`
-	CmdLine     string  `json:"cmdline"`
-	NumThreads  int32   `json:"num_threads"`
-}
+	"github.com/Tenkydo/monprojet/protocol"
+)
 
-// Structure complète pour l'envoi
-type SystemData struct {
-	CPUInfo     CPUInfo             `json:"cpu_info"`
-	CoreData    []CPUClientCoreData `json:"core_data"`
-	Processes   []ProcessInfo       `json:"processes"`
-	Hostname    string              `json:"hostname"`
-	OS          string              `json:"os"`
-	Platform    string              `json:"platform"`
-	CollectedAt string              `json:"collected_at"`
-}
+// Format d'échange partagé avec le serveur (voir protocol/)
+type (
+	CPUInfo           = protocol.CPUInfo
+	CPUClientCoreData = protocol.CPUClientCoreData
+	ProcessInfo       = protocol.ProcessInfo
+	SystemData        = protocol.SystemData
+)
 
 func getCPUInfo() (CPUInfo, error) {
 	cpuInfos, err := cpu.Info()
@@ -69,15 +48,6 @@ func getCPUInfo() (CPUInfo, error) {
 	}
 
 	info := cpuInfos[0]
-	
-	// Construction de l'User-Agent personnalisé
-	hostname, _ := os.Hostname()
-	userAgent := fmt.Sprintf("CPUAgent/1.0 (%s; %s %s; %s)",
-		hostname,
-		runtime.GOOS,
-		runtime.GOARCH,
-		info.ModelName,
-	)
 
 	return CPUInfo{
 		VendorID:  info.VendorID,
@@ -85,7 +55,6 @@ func getCPUInfo() (CPUInfo, error) {
 		Model:     info.ModelName,
 		MHz:       fmt.Sprintf("%.0f", info.Mhz),
 		CacheSize: fmt.Sprintf("%d", info.CacheSize),
-		UserAgent: userAgent,
 	}, nil
 }
 
@@ -96,18 +65,10 @@ func getCPUUsagePerCore() ([]CPUClientCoreData, error) {
 		return nil, err
 	}
 
-	hostname, _ := os.Hostname()
-	userAgent := fmt.Sprintf("CPUAgent/1.0 (%s; %s %s)",
-		hostname,
-		runtime.GOOS,
-		runtime.GOARCH,
-	)
-
 	var coreData []CPUClientCoreData
 	for i, percent := range percentages {
 		coreData = append(coreData, CPUClientCoreData{
 			Core:       i,
-			UserAgent:  userAgent,
 			CPUPercent: percent,
 			Timestamp:  time.Now().Format(time.RFC3339),
 		})
@@ -212,6 +173,9 @@ func getSystemInfo() (string, string, string, error) {
 	return hostname, hostInfo.OS, hostInfo.Platform, nil
 }
 
+// Numéro de la dernière collecte, commun à tous les serveurs
+var sequence uint64
+
 func collectSystemData() (*SystemData, error) {
 	fmt.Println("🔄 Collecte des informations système...")
 
@@ -240,7 +204,19 @@ func collectSystemData() (*SystemData, error) {
 		log.Printf("⚠️  Erreur collecte système: %v", err)
 	}
 
+	// Construction de l'User-Agent personnalisé
+	userAgent := fmt.Sprintf("CPUAgent/1.0 (%s; %s %s; %s)",
+		hostname,
+		runtime.GOOS,
+		runtime.GOARCH,
+		cpuInfo.Model,
+	)
+
+	sequence++
 	return &SystemData{
+		SchemaVersion: protocol.CurrentVersion,
+		Sequence:      sequence,
+		UserAgent:     userAgent,
 		CPUInfo:     cpuInfo,
 		CoreData:    coreData,
 		Processes:   processes,
@@ -251,32 +227,344 @@ func collectSystemData() (*SystemData, error) {
 	}, nil
 }
 
-func sendDataToServer(data *SystemData, serverURL string) error {
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("erreur sérialisation JSON: %v", err)
+// Identifiants de l'agent : jeton "<id>.<secret>" émis par l'API d'administration
+type agentCredentials struct {
+	token string
+	mode  string // hmac (défaut) ou bearer
+}
+
+var credentials agentCredentials
+
+// Ajoute l'authentification à une requête : en-tête Bearer, ou signature
+// HMAC-SHA256 de "méthode\nchemin\nrequête\nhorodatage\nnonce\nsha256(corps)"
+func authenticateRequest(req *http.Request, body []byte) error {
+	if credentials.token == "" {
+		return nil
+	}
+	if credentials.mode == "bearer" {
+		req.Header.Set("Authorization", "Bearer "+credentials.token)
+		return nil
+	}
+	id, secret, ok := strings.Cut(credentials.token, ".")
+	if !ok {
+		return fmt.Errorf("jeton invalide (attendu: <id>.<secret>)")
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s", req.Method, req.URL.Path, req.URL.RawQuery, timestamp, nonceHex, hex.EncodeToString(sum[:]))
+
+	req.Header.Set("X-Agent-Key", id)
+	req.Header.Set("X-Agent-Timestamp", timestamp)
+	req.Header.Set("X-Agent-Nonce", nonceHex)
+	req.Header.Set("X-Agent-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// Options de connexion au serveur
+type transportOptions struct {
+	caFile   string // bundle de CA supplémentaire
+	certFile string // certificat client (mTLS)
+	keyFile  string
+	proxy    string // vide : variables HTTP(S)_PROXY
+	timeout  time.Duration
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Client HTTP configuré (CA, certificat client, proxy, délai)
+func newHTTPClient(opts transportOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.caFile != "" {
+		pem, err := os.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: aucun certificat PEM", opts.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.certFile != "" || opts.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("certificat client: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if opts.proxy != "" {
+		proxyURL, err := url.Parse(opts.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy invalide: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport, Timeout: opts.timeout}, nil
+}
+
+func sendDataToServer(data *SystemData, serverURL string) error {
 	// Affiche un résumé des données collectées
 	fmt.Printf("📊 Données collectées:\n")
 	fmt.Printf("   - CPU: %s %s\n", data.CPUInfo.VendorID, data.CPUInfo.Model)
 	fmt.Printf("   - Cœurs: %d\n", len(data.CoreData))
 	fmt.Printf("   - Processus: %d\n", len(data.Processes))
-	fmt.Printf("   - Taille JSON: %d bytes\n", len(jsonData))
 
-	// Envoi au serveur
-	resp, err := http.Post(serverURL+"/cpu", "application/json", bytes.NewBuffer(jsonData))
+	// Envoi au serveur dans le format le plus compact qu'il annonce, les
+	// processus en delta s'il a acquitté un envoi précédent ; s'il est
+	// saturé (429/503), on attend le délai indiqué par Retry-After avant de
+	// réessayer ; s'il refuse la version du schéma ou ne connaît plus la
+	// base du delta, on renvoie aussitôt
+	for attempt := 1; ; attempt++ {
+		caps := capsFor(serverURL)
+		snapshot, isDelta := withProcessDelta(*data, serverURL, caps)
+		p, err := encodeSnapshot(snapshot, caps)
+		if err != nil {
+			return fmt.Errorf("erreur sérialisation: %v", err)
+		}
+		kind := "complet"
+		if isDelta {
+			kind = fmt.Sprintf("delta sur #%d: +%d -%d", snapshot.BaseSequence,
+				len(snapshot.ProcessDelta.Upserted), len(snapshot.ProcessDelta.Removed))
+		}
+		fmt.Printf("   - Taille: %d bytes (%s%s, schéma v%d, %s)\n", len(p.body), p.contentType, p.encodingSuffix(), caps.schema, kind)
+
+		ack, wait, err := postSnapshot(serverURL, p)
+		if err == nil {
+			// base des prochains deltas : seulement si le serveur acquitte
+			// cet envoi (un ancien serveur n'acquitte jamais)
+			if ack != 0 && ack == data.Sequence {
+				bases[serverURL] = snapshotBase{sequence: ack, processes: data.Processes}
+			} else {
+				delete(bases, serverURL)
+			}
+			fmt.Printf("✅ Données envoyées avec succès au serveur!\n")
+			return nil
+		}
+		if errors.Is(err, errResync) {
+			delete(bases, serverURL)
+		}
+		if attempt > maxRetries {
+			return err
+		}
+		if errors.Is(err, errSchemaRejected) || errors.Is(err, errResync) {
+			continue
+		}
+		if wait == 0 || wait > maxRetryWait {
+			return err
+		}
+		fmt.Printf("⏳ %s saturé, nouvel essai dans %v\n", serverURL, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Ce que chaque serveur annonce dans ses réponses ; avant la première
+// réponse : schéma le plus récent, en JSON non compressé
+type serverCaps struct {
+	schema   int
+	protobuf bool
+	gzip     bool
+}
+
+var knownServers = map[string]serverCaps{}
+
+var errSchemaRejected = errors.New("version du schéma refusée par le serveur")
+
+var errResync = errors.New("base du delta inconnue du serveur, instantané complet requis")
+
+// Dernier envoi acquitté par un serveur, base des deltas de processus
+type snapshotBase struct {
+	sequence  uint64
+	processes []ProcessInfo
+}
+
+var bases = map[string]snapshotBase{}
+
+// Remplace la liste des processus par un delta sur la base acquittée par
+// le serveur (schéma v2) ; instantané complet sinon, ou si rien n'y gagne
+func withProcessDelta(data SystemData, serverURL string, caps serverCaps) (SystemData, bool) {
+	base, ok := bases[serverURL]
+	if !ok || caps.schema < 2 || data.Sequence <= base.sequence {
+		return data, false
+	}
+	delta := protocol.DiffProcesses(base.processes, data.Processes)
+	if len(delta.Upserted) == len(data.Processes) {
+		return data, false
+	}
+	data.Processes = nil
+	data.BaseSequence = base.sequence
+	data.ProcessDelta = &delta
+	return data, true
+}
+
+func capsFor(serverURL string) serverCaps {
+	if caps, ok := knownServers[serverURL]; ok {
+		return caps
+	}
+	return serverCaps{schema: protocol.CurrentVersion}
+}
+
+// Retient les capacités annoncées dans une réponse ; vrai si la version du
+// schéma a changé
+func noteServer(serverURL string, header http.Header) bool {
+	previous := capsFor(serverURL)
+	caps := previous
+	caps.protobuf = listContains(header.Get(protocol.HeaderAcceptPost), protocol.ContentTypeProtobuf)
+	caps.gzip = listContains(header.Get(protocol.HeaderAcceptEncoding), "gzip")
+
+	versions, err := protocol.ParseVersions(header.Get(protocol.HeaderVersions))
+	if err != nil {
+		log.Printf("⚠️  %s: en-tête %s illisible: %v", serverURL, protocol.HeaderVersions, err)
+	} else if v, ok := protocol.Negotiate(versions); ok {
+		caps.schema = v
+	} else {
+		log.Printf("⚠️  %s: aucune version du schéma commune (serveur: %v)", serverURL, versions)
+	}
+
+	knownServers[serverURL] = caps
+	if caps != previous {
+		fmt.Printf("🔀 %s: schéma v%d, protobuf: %v, gzip: %v\n", serverURL, caps.schema, caps.protobuf, caps.gzip)
+	}
+	return caps.schema != previous.schema
+}
+
+// Vrai si une liste d'en-tête ("a, b;q=1") contient value
+func listContains(list, value string) bool {
+	for _, item := range strings.Split(list, ",") {
+		item, _, _ = strings.Cut(item, ";")
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Corps d'un envoi et ses en-têtes de format
+type payload struct {
+	body        []byte
+	contentType string
+	encoding    string
+}
+
+func (p payload) encodingSuffix() string {
+	if p.encoding == "" {
+		return ""
+	}
+	return "+" + p.encoding
+}
+
+// En dessous, la compression ne fait rien gagner
+const gzipThreshold = 1024
+
+// Encode l'instantané : protobuf si le serveur l'accepte (schéma v1 et
+// plus), JSON sinon ; compressé en gzip s'il l'annonce
+func encodeSnapshot(data SystemData, caps serverCaps) (payload, error) {
+	var p payload
+	if caps.protobuf && caps.schema >= 1 {
+		body, err := protocol.MarshalBinary(data, caps.schema)
+		if err != nil {
+			return p, err
+		}
+		p = payload{body: body, contentType: protocol.ContentTypeProtobuf}
+	} else {
+		body, err := protocol.Marshal(data, caps.schema)
+		if err != nil {
+			return p, err
+		}
+		p = payload{body: body, contentType: protocol.ContentTypeJSON}
+	}
+	if caps.gzip && len(p.body) > gzipThreshold {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(p.body); err != nil {
+			return p, err
+		}
+		if err := zw.Close(); err != nil {
+			return p, err
+		}
+		p.body, p.encoding = buf.Bytes(), "gzip"
+	}
+	return p, nil
+}
+
+// Attente maximale acceptée et nombre de nouveaux essais sur Retry-After
+var (
+	maxRetryWait = 30 * time.Second
+	maxRetries   = 2
+)
+
+// Un envoi ; retourne la séquence acquittée par le serveur, ou l'attente
+// qu'il demande s'il est saturé
+func postSnapshot(serverURL string, p payload) (uint64, time.Duration, error) {
+	// nouvelle requête à chaque essai : la signature porte un nonce unique
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/cpu", bytes.NewReader(p.body))
+	if err != nil {
+		return 0, 0, fmt.Errorf("erreur création requête: %v", err)
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	if p.encoding != "" {
+		req.Header.Set("Content-Encoding", p.encoding)
+	}
+	if err := authenticateRequest(req, p.body); err != nil {
+		return 0, 0, fmt.Errorf("erreur authentification: %v", err)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("erreur envoi au serveur: %v", err)
+		return 0, 0, fmt.Errorf("erreur envoi au serveur: %v", err)
 	}
 	defer resp.Body.Close()
+	changed := noteServer(serverURL, resp.Header)
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest && changed:
+		return 0, 0, errSchemaRejected
+	case resp.StatusCode == http.StatusConflict && resp.Header.Get(protocol.HeaderResync) != "":
+		return 0, 0, errResync
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		ack, _ := strconv.ParseUint(resp.Header.Get(protocol.HeaderAck), 10, 64)
+		return ack, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return 0, wait, fmt.Errorf("serveur saturé (code %d), Retry-After: %v", resp.StatusCode, wait)
+	}
+	return 0, 0, fmt.Errorf("serveur a répondu avec le code: %d", resp.StatusCode)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("serveur a répondu avec le code: %d", resp.StatusCode)
+// URL d'un serveur, sans "/" final ; sans schéma explicite, HTTPS
+// seulement si TLS est configuré côté agent (-ca ou -cert), HTTP sinon
+// comme le serveur démarré sans -tls-cert
+func normalizeServerURL(server string, tlsConfigured bool) string {
+	server = strings.TrimRight(strings.TrimSpace(server), "/")
+	if !strings.Contains(server, "://") {
+		scheme := "http://"
+		if tlsConfigured {
+			scheme = "https://"
+		}
+		server = scheme + server
 	}
+	return server
+}
 
-	fmt.Printf("✅ Données envoyées avec succès au serveur!\n")
-	return nil
+// Délai d'un en-tête Retry-After : secondes ou date HTTP ; 1s par défaut
+func retryAfter(value string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return time.Second
 }
 
 func saveLocalCopy(data *SystemData) error {
@@ -324,27 +612,59 @@ func main() {
 	fmt.Println("🚀 Démarrage de l'Agent CPU Client avec Monitoring des Processus")
 	fmt.Println("===============================================================")
 
-	// Configuration par défaut : liste de serveurs
-	servers := []string{
-		"http://192.168.54.203:8888",
-		"http://192.168.54.109:8888",
+	// Serveurs cibles : -servers, $CPU_AGENT_SERVERS ou le serveur local
+	serverList := os.Getenv("CPU_AGENT_SERVERS")
+	if serverList == "" {
+		serverList = "localhost:8888"
 	}
-
-	// Si on passe des IPs en argument, elles remplacent la liste
-	if len(os.Args) > 1 {
-		servers = os.Args[1:]
+	flag.StringVar(&serverList, "servers", serverList, "serveurs cibles séparés par des virgules, HTTPS par défaut si -ca ou -cert (défaut: $CPU_AGENT_SERVERS ou localhost:8888)")
+	interval := 30 * time.Second
+	flag.DurationVar(&interval, "interval", interval, "intervalle du monitoring continu")
+	flag.StringVar(&credentials.token, "token", os.Getenv("CPU_AGENT_TOKEN"), "jeton de l'agent <id>.<secret> (défaut: $CPU_AGENT_TOKEN)")
+	flag.StringVar(&credentials.mode, "auth", "hmac", "mode d'authentification: hmac ou bearer")
+	var transport transportOptions
+	flag.StringVar(&transport.caFile, "ca", "", "bundle PEM de CA pour vérifier le serveur")
+	flag.StringVar(&transport.certFile, "cert", "", "certificat client PEM (mTLS)")
+	flag.StringVar(&transport.keyFile, "key", "", "clé privée du certificat client")
+	flag.StringVar(&transport.proxy, "proxy", "", "proxy HTTP (défaut: $HTTPS_PROXY / $HTTP_PROXY)")
+	flag.DurationVar(&transport.timeout, "timeout", 10*time.Second, "délai maximal d'un envoi")
+	flag.DurationVar(&maxRetryWait, "max-retry-wait", maxRetryWait, "attente maximale acceptée sur Retry-After avant de renoncer à un envoi")
+	flag.Parse()
+	args := flag.Args()
+
+	client, err := newHTTPClient(transport)
+	if err != nil {
+		log.Fatalf("❌ Configuration réseau invalide: %v", err)
 	}
+	httpClient = client
 
-	// Paramètres par défaut
-	interval := 30 * time.Second
-	if len(os.Args) > 2 {
-		if intervalSec, err := strconv.Atoi(os.Args[2]); err == nil {
+	// Forme historique : serveurs en arguments, suivis éventuellement de
+	// l'intervalle en secondes
+	var servers []string
+	for _, arg := range args {
+		if intervalSec, err := strconv.Atoi(arg); err == nil {
 			interval = time.Duration(intervalSec) * time.Second
+			continue
 		}
+		servers = append(servers, arg)
+	}
+	if len(servers) == 0 {
+		servers = strings.Split(serverList, ",")
+	}
+	for i, server := range servers {
+		servers[i] = normalizeServerURL(server, transport.caFile != "" || transport.certFile != "")
+	}
+	if interval <= 0 {
+		log.Fatalf("❌ Intervalle invalide: %v", interval)
 	}
 
 	fmt.Printf("🌐 Serveurs cibles: %v\n", servers)
 	fmt.Printf("⏱️  Intervalle: %v\n", interval)
+	if credentials.token != "" {
+		fmt.Printf("🔑 Authentification: %s\n", credentials.mode)
+	} else {
+		fmt.Println("⚠️  Aucun jeton : les envois seront refusés si le serveur exige l'authentification")
+	}
 	fmt.Println("===============================================================")
 
 	// Test initial
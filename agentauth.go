@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Écart toléré entre l'horloge de l'agent et celle du serveur
+const signatureSkew = 5 * time.Minute
+
+// En-têtes de la signature HMAC des agents
+const (
+	headerAgentKey       = "X-Agent-Key"
+	headerAgentTimestamp = "X-Agent-Timestamp"
+	headerAgentNonce     = "X-Agent-Nonce"
+	headerAgentSignature = "X-Agent-Signature"
+)
+
+// Clé d'un agent, liée à un nom d'hôte. Le secret est conservé tel quel :
+// il sert aussi à vérifier les signatures HMAC.
+type AgentKey struct {
+	ID        string     `json:"id"`
+	Hostname  string     `json:"hostname"`
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Identité authentifiée d'un envoi
+type AgentIdentity struct {
+	KeyID    string
+	Hostname string
+}
+
+var (
+	errUnauthenticated = errors.New("authentification requise")
+	errBadCredentials  = errors.New("identifiants invalides")
+	errReplay          = errors.New("requête rejouée")
+)
+
+// Clés des agents, persistées dans un fichier JSON (0600)
+type AgentKeyStore struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*AgentKey
+	nonces map[string]time.Time // nonce -> expiration
+	pruned time.Time
+
+	// mode auto : envois sans identifiants acceptés tant qu'aucune clé n'a
+	// été émise (migration depuis un serveur sans authentification)
+	auto       bool
+	warnedOpen sync.Once
+}
+
+func OpenAgentKeyStore(path string) (*AgentKeyStore, error) {
+	s := &AgentKeyStore{path: path, keys: make(map[string]*AgentKey), nonces: make(map[string]time.Time)}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []*AgentKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, k := range file.Keys {
+		s.keys[k.ID] = k
+	}
+	return s, nil
+}
+
+func (s *AgentKeyStore) saveLocked() error {
+	keys := make([]*AgentKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	raw, err := json.MarshalIndent(map[string]interface{}{"keys": keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, raw, 0600)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Crée une clé pour un hôte ; le jeton complet n'est renvoyé qu'ici
+func (s *AgentKeyStore) Issue(hostname string) (AgentKey, error) {
+	key := &AgentKey{
+		ID:        randomHex(8),
+		Hostname:  hostname,
+		Secret:    randomHex(32),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	if err := s.saveLocked(); err != nil {
+		delete(s.keys, key.ID)
+		return AgentKey{}, err
+	}
+	return *key, nil
+}
+
+// Révoque une clé ; elle reste listée avec sa date de révocation
+func (s *AgentKeyStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		if err := s.saveLocked(); err != nil {
+			key.RevokedAt = nil
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Vrai si les envois doivent être authentifiés ; en mode auto, dès la
+// première clé émise (une clé révoquée compte : révoquer ne rouvre pas)
+func (s *AgentKeyStore) Enforced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.auto || len(s.keys) > 0
+}
+
+// Clés sans leur secret
+func (s *AgentKeyStore) List() []AgentKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]AgentKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		copied := *k
+		copied.Secret = ""
+		keys = append(keys, copied)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+func (s *AgentKeyStore) active(id string) (AgentKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return AgentKey{}, false
+	}
+	return *key, true
+}
+
+// Enregistre un nonce ; faux s'il a déjà été vu pendant la fenêtre de validité
+func (s *AgentKeyStore) useNonce(keyID, nonce string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.pruned) > time.Minute {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.pruned = now
+	}
+	id := keyID + ":" + nonce
+	if _, seen := s.nonces[id]; seen {
+		return false
+	}
+	s.nonces[id] = now.Add(2 * signatureSkew)
+	return true
+}
+
+// Authentifie une requête : "Authorization: Bearer <id>.<secret>" ou
+// signature HMAC-SHA256 (en-têtes X-Agent-*). body est le corps brut.
+func (s *AgentKeyStore) Authenticate(r *http.Request, body []byte, now time.Time) (AgentIdentity, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			// Telegraf (sortie influxdb_v2) envoie "Token <jeton>"
+			token, ok = strings.CutPrefix(auth, "Token ")
+		}
+		id, secret, found := strings.Cut(strings.TrimSpace(token), ".")
+		if !ok || !found {
+			return AgentIdentity{}, errBadCredentials
+		}
+		key, ok := s.active(id)
+		if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(key.Secret)) != 1 {
+			return AgentIdentity{}, errBadCredentials
+		}
+		return AgentIdentity{KeyID: key.ID, Hostname: key.Hostname}, nil
+	}
+
+	id := r.Header.Get(headerAgentKey)
+	if id == "" {
+		return AgentIdentity{}, errUnauthenticated
+	}
+	key, ok := s.active(id)
+	if !ok {
+		return AgentIdentity{}, errBadCredentials
+	}
+	stamp := r.Header.Get(headerAgentTimestamp)
+	nonce := r.Header.Get(headerAgentNonce)
+	unix, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil || nonce == "" {
+		return AgentIdentity{}, errBadCredentials
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > signatureSkew || d < -signatureSkew {
+		return AgentIdentity{}, fmt.Errorf("horodatage hors de la fenêtre de %v", signatureSkew)
+	}
+	expected := signRequest(key.Secret, r.Method, r.URL.Path, r.URL.RawQuery, stamp, nonce, body)
+	given, err := hex.DecodeString(r.Header.Get(headerAgentSignature))
+	if err != nil || !hmac.Equal(given, expected) {
+		return AgentIdentity{}, errBadCredentials
+	}
+	if !s.useNonce(key.ID, nonce, now) {
+		return AgentIdentity{}, errReplay
+	}
+	return AgentIdentity{KeyID: key.ID, Hostname: key.Hostname}, nil
+}
+
+// HMAC-SHA256 de "méthode\nchemin\nrequête\nhorodatage\nnonce\nsha256(corps)" ;
+// la requête (RawQuery, telle qu'envoyée) est signée : precision, db...
+// changent l'interprétation du corps
+func signRequest(secret, method, path, query, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s", method, path, query, timestamp, nonce, hex.EncodeToString(sum[:]))
+	return mac.Sum(nil)
+}
+
+type agentContextKey struct{}
+
+// Identité de l'agent attachée à la requête (absente si l'authentification est désactivée)
+func agentIdentity(r *http.Request) (AgentIdentity, bool) {
+	id, ok := r.Context().Value(agentContextKey{}).(AgentIdentity)
+	return id, ok
+}
+
+// Vérifie qu'un envoi ne concerne que l'hôte lié aux identifiants
+func authorizeHost(r *http.Request, hostname string) error {
+	id, ok := agentIdentity(r)
+	if !ok || id.Hostname == hostname {
+		return nil
+	}
+	return fmt.Errorf("identité %s non autorisée pour l'hôte %q", id.KeyID, hostname)
+}
+
+// Protège un point d'ingestion : un certificat client vérifié suffit, sinon
+// jeton ou signature ; sans magasin de clés, laisse passer
+func requireAgent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if identity, ok := certificateIdentity(r); ok {
+			next(w, r.WithContext(context.WithValue(r.Context(), agentContextKey{}, identity)))
+			return
+		}
+		if agentKeys == nil {
+			next(w, r)
+			return
+		}
+		if !agentKeys.Enforced() {
+			agentKeys.warnedOpen.Do(func() {
+				log.Printf("⚠️  Envoi non authentifié accepté (-agent-auth auto, aucune clé émise)")
+			})
+			next(w, r)
+			return
+		}
+		limitBody(w, r)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSONError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		identity, err := agentKeys.Authenticate(r, body, time.Now())
+		if err != nil {
+			counters.authFailures.Add(1)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cpu-monitor"`)
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), agentContextKey{}, identity)))
+	}
+}
+
+// Jeton d'administration (-admin-token) pour l'automatisation ; vide : seules
+// les sessions des comptes admin accèdent à /api/admin
+var adminToken string
+
+// API d'administration des clés d'agents
+func handleAgentKeys(w http.ResponseWriter, r *http.Request) {
+	if agentKeys == nil {
+		writeJSONError(w, http.StatusNotFound, "authentification des agents désactivée")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": agentKeys.List()})
+
+	case http.MethodPost:
+		var req struct {
+			Hostname string `json:"hostname"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hostname == "" {
+			writeJSONError(w, http.StatusBadRequest, "hostname requis")
+			return
+		}
+		key, err := agentKeys.Issue(req.Hostname)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		fmt.Printf("🔑 Clé %s émise pour %s\n", key.ID, key.Hostname)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         key.ID,
+			"hostname":   key.Hostname,
+			"token":      key.ID + "." + key.Secret,
+			"created_at": key.CreatedAt,
+		})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		found, err := agentKeys.Revoke(id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "clé inconnue")
+			return
+		}
+		fmt.Printf("🔒 Clé %s révoquée\n", id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "id": id})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requête signée comme par l'agent
+func signedRequest(key AgentKey, target, body string, at time.Time, nonce string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	stamp := strconv.FormatInt(at.Unix(), 10)
+	sig := signRequest(key.Secret, req.Method, req.URL.Path, req.URL.RawQuery, stamp, nonce, []byte(body))
+	req.Header.Set(headerAgentKey, key.ID)
+	req.Header.Set(headerAgentTimestamp, stamp)
+	req.Header.Set(headerAgentNonce, nonce)
+	req.Header.Set(headerAgentSignature, hex.EncodeToString(sig))
+	return req
+}
+
+func TestAgentAuthenticate(t *testing.T) {
+	keys, err := OpenAgentKeyStore(filepath.Join(t.TempDir(), "agent_keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.Issue("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := keys.Issue("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	const body = `{"hostname":"web"}`
+
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		body    string // corps reçu par le serveur (défaut : body)
+		wantErr bool
+	}{
+		{"jeton bearer", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+key.ID+"."+key.Secret)
+			return req
+		}, "", false},
+		{"jeton Telegraf", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/api/v2/write", strings.NewReader(body))
+			req.Header.Set("Authorization", "Token "+key.ID+"."+key.Secret)
+			return req
+		}, "", false},
+		{"mauvais secret", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+key.ID+".deadbeef")
+			return req
+		}, "", true},
+		{"clé révoquée", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+revoked.ID+"."+revoked.Secret)
+			return req
+		}, "", true},
+		{"sans identifiants", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(body))
+		}, "", true},
+		{"signature valide", func() *http.Request {
+			return signedRequest(key, "/write?precision=s", body, now, "n1")
+		}, "", false},
+		{"requête modifiée", func() *http.Request {
+			req := signedRequest(key, "/write?precision=s", body, now, "n2")
+			req.URL.RawQuery = "precision=ms"
+			return req
+		}, "", true},
+		{"corps modifié", func() *http.Request {
+			return signedRequest(key, "/cpu", body, now, "n3")
+		}, `{"hostname":"db"}`, true},
+		// même nonce que « signature valide »
+		{"nonce rejoué", func() *http.Request {
+			return signedRequest(key, "/write?precision=s", body, now, "n1")
+		}, "", true},
+		{"horodatage trop ancien", func() *http.Request {
+			return signedRequest(key, "/cpu", body, now.Add(-signatureSkew-time.Minute), "n4")
+		}, "", true},
+		{"horodatage dans le futur", func() *http.Request {
+			return signedRequest(key, "/cpu", body, now.Add(signatureSkew+time.Minute), "n5")
+		}, "", true},
+		{"horodatage dans la fenêtre", func() *http.Request {
+			return signedRequest(key, "/cpu", body, now.Add(-signatureSkew/2), "n6")
+		}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := tt.body
+			if received == "" {
+				received = body
+			}
+			id, err := keys.Authenticate(tt.req(), []byte(received), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate = %+v, %v ; erreur attendue: %v", id, err, tt.wantErr)
+			}
+			if err == nil && (id.KeyID != key.ID || id.Hostname != "web") {
+				t.Errorf("identité = %+v", id)
+			}
+		})
+	}
+}
+
+// Le point d'ingestion relit le corps transmis et n'appelle le handler
+// qu'avec une requête authentifiée
+func TestRequireAgent(t *testing.T) {
+	keys, err := OpenAgentKeyStore(filepath.Join(t.TempDir(), "agent_keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.Issue("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentKeys = keys
+	defer func() { agentKeys = nil }()
+
+	const body = `{"hostname":"web"}`
+	handler := requireAgent(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := agentIdentity(r)
+		w.Write([]byte(id.Hostname))
+	})
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"signée", signedRequest(key, "/cpu?v=2", body, time.Now(), "a"), http.StatusOK},
+		{"rejouée", signedRequest(key, "/cpu?v=2", body, time.Now(), "a"), http.StatusUnauthorized},
+		{"sans identifiants", httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(body)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, tt.req)
+			if rec.Code != tt.want {
+				t.Fatalf("statut %d, attendu %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != "web" {
+				t.Errorf("identité transmise = %q", rec.Body)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate absent")
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// États d'une alerte
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Types de règles
+const (
+	RuleHostCPU    = "host_cpu"    // moyenne des cœurs de l'hôte
+	RuleCoreCPU    = "core_cpu"    // chaque cœur
+	RuleProcessCPU = "process_cpu" // chaque processus (filtré par process)
+	RuleProcessMem = "process_mem"
+)
+
+// Durée de conservation des alertes résolues
+const resolvedRetention = time.Hour
+
+// Durée lisible en JSON ("5m", "30s")
+type ruleDuration time.Duration
+
+func (d *ruleDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = ruleDuration(parsed)
+	return nil
+}
+
+func (d ruleDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Règle d'alerte, telle que décrite dans le fichier de configuration
+type AlertRule struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"`
+	Op        string            `json:"op,omitempty"` // ">" (défaut) ou ">="
+	Threshold float64           `json:"threshold"`
+	For       ruleDuration      `json:"for"`
+	Severity  string            `json:"severity,omitempty"`
+	Process   string            `json:"process,omitempty"` // regex sur le nom du processus
+	Match     map[string]string `json:"match,omitempty"`   // regex par label : hostname, os, platform
+
+	process *regexp.Regexp
+	match   map[string]*regexp.Regexp
+}
+
+// Fichier de règles
+type AlertRulesFile struct {
+	Rules []AlertRule `json:"rules"`
+}
+
+// Alerte active ou récemment résolue
+type Alert struct {
+	Rule        string            `json:"rule"`
+	Severity    string            `json:"severity,omitempty"`
+	Hostname    string            `json:"hostname"`
+	Labels      map[string]string `json:"labels"`
+	State       string            `json:"state"`
+	Value       float64           `json:"value"`
+	Threshold   float64           `json:"threshold"`
+	Summary     string            `json:"summary"`
+	ActiveSince time.Time         `json:"active_since"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Changement d'état d'une alerte (pour les notifications)
+type AlertChange struct {
+	Alert Alert  `json:"alert"`
+	From  string `json:"from"`
+}
+
+// Moteur de règles, évalué à chaque ingestion et périodiquement (Tick)
+type AlertEngine struct {
+	mu     sync.Mutex
+	rules  []AlertRule
+	alerts map[string]*Alert // clé : règle|hôte|instance
+}
+
+// Lit et valide un fichier de règles JSON
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file AlertRulesFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// le nom identifie les alertes d'une règle : il doit être unique
+	names := make(map[string]bool, len(file.Rules))
+	for i := range file.Rules {
+		if err := file.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("%s: règle %d (%s): %v", path, i, file.Rules[i].Name, err)
+		}
+		if names[file.Rules[i].Name] {
+			return nil, fmt.Errorf("%s: règle %d: nom %q déjà utilisé", path, i, file.Rules[i].Name)
+		}
+		names[file.Rules[i].Name] = true
+	}
+	return file.Rules, nil
+}
+
+func (r *AlertRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("name requis")
+	}
+	switch r.Kind {
+	case RuleHostCPU, RuleCoreCPU, RuleProcessCPU, RuleProcessMem:
+	default:
+		return fmt.Errorf("kind inconnu: %q", r.Kind)
+	}
+	switch r.Op {
+	case "":
+		r.Op = ">"
+	case ">", ">=":
+	default:
+		return fmt.Errorf("op doit valoir > ou >=")
+	}
+	if r.Process != "" {
+		re, err := regexp.Compile(r.Process)
+		if err != nil {
+			return fmt.Errorf("process: %v", err)
+		}
+		r.process = re
+	}
+	r.match = make(map[string]*regexp.Regexp, len(r.Match))
+	for label, pattern := range r.Match {
+		switch label {
+		case "hostname", "os", "platform":
+		default:
+			return fmt.Errorf("label inconnu: %q", label)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("match %s: %v", label, err)
+		}
+		r.match[label] = re
+	}
+	return nil
+}
+
+func NewAlertEngine(rules []AlertRule) *AlertEngine {
+	return &AlertEngine{rules: rules, alerts: make(map[string]*Alert)}
+}
+
+func (r *AlertRule) matches(labels map[string]string) bool {
+	for label, re := range r.match {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AlertRule) exceeds(value float64) bool {
+	if r.Op == ">=" {
+		return value >= r.Threshold
+	}
+	return value > r.Threshold
+}
+
+// Valeur observée pour une instance (cœur, processus...) d'une règle
+type alertSample struct {
+	instance string
+	labels   map[string]string
+	value    float64
+	summary  string
+}
+
+// Instances évaluées par une règle sur un instantané
+func (r *AlertRule) samples(data SystemData) []alertSample {
+	var samples []alertSample
+	switch r.Kind {
+	case RuleHostCPU:
+		if len(data.CoreData) == 0 {
+			return nil
+		}
+		total := 0.0
+		for _, core := range data.CoreData {
+			total += core.CPUPercent
+		}
+		avg := total / float64(len(data.CoreData))
+		samples = append(samples, alertSample{
+			value:   avg,
+			summary: fmt.Sprintf("CPU moyen de %s à %.1f%%", data.Hostname, avg),
+		})
+	case RuleCoreCPU:
+		for _, core := range data.CoreData {
+			samples = append(samples, alertSample{
+				instance: strconv.Itoa(core.Core),
+				labels:   map[string]string{"core": strconv.Itoa(core.Core)},
+				value:    core.CPUPercent,
+				summary:  fmt.Sprintf("Cœur %d de %s à %.1f%%", core.Core, data.Hostname, core.CPUPercent),
+			})
+		}
+	case RuleProcessCPU, RuleProcessMem:
+		for _, proc := range data.Processes {
+			if r.process != nil && !r.process.MatchString(proc.Name) {
+				continue
+			}
+			value, what := proc.CPUPercent, "CPU"
+			if r.Kind == RuleProcessMem {
+				value, what = float64(proc.MemPercent), "mémoire"
+			}
+			pid := strconv.Itoa(int(proc.PID))
+			samples = append(samples, alertSample{
+				instance: pid + "/" + proc.Name,
+				labels:   map[string]string{"process": proc.Name, "pid": pid, "username": proc.Username},
+				value:    value,
+				summary:  fmt.Sprintf("%s (PID %s) sur %s: %s à %.1f%%", proc.Name, pid, data.Hostname, what, value),
+			})
+		}
+	}
+	return samples
+}
+
+// Évalue les règles sur un instantané et renvoie les changements d'état
+func (e *AlertEngine) Evaluate(data SystemData, now time.Time) []AlertChange {
+	hostLabels := map[string]string{
+		"hostname": data.Hostname,
+		"os":       data.OS,
+		"platform": data.Platform,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var changes []AlertChange
+	seen := make(map[string]bool)
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.matches(hostLabels) {
+			continue
+		}
+		prefix := rule.Name + "|" + data.Hostname + "|"
+		for _, sample := range rule.samples(data) {
+			key := prefix + sample.instance
+			if !rule.exceeds(sample.value) {
+				continue
+			}
+			seen[key] = true
+
+			alert, ok := e.alerts[key]
+			if !ok || alert.State == AlertResolved {
+				labels := map[string]string{"alertname": rule.Name}
+				for k, v := range hostLabels {
+					labels[k] = v
+				}
+				for k, v := range sample.labels {
+					labels[k] = v
+				}
+				alert = &Alert{
+					Rule:        rule.Name,
+					Severity:    rule.Severity,
+					Hostname:    data.Hostname,
+					Labels:      labels,
+					State:       AlertPending,
+					Threshold:   rule.Threshold,
+					ActiveSince: now,
+				}
+				e.alerts[key] = alert
+				changes = append(changes, AlertChange{Alert: *alert, From: ""})
+			}
+			alert.Value = sample.value
+			alert.Summary = sample.summary
+			alert.UpdatedAt = now
+			if alert.State == AlertPending && now.Sub(alert.ActiveSince) >= time.Duration(rule.For) {
+				fired := now
+				alert.State = AlertFiring
+				alert.FiredAt = &fired
+				changes = append(changes, AlertChange{Alert: *alert, From: AlertPending})
+			}
+		}
+
+		// Instances de cette règle sur cet hôte qui ne dépassent plus le seuil
+		for key, alert := range e.alerts {
+			if seen[key] || alert.State == AlertResolved || len(key) < len(prefix) || key[:len(prefix)] != prefix {
+				continue
+			}
+			if change, ok := e.clear(key, alert, now); ok {
+				changes = append(changes, change)
+			}
+		}
+	}
+	e.purgeResolved(now)
+	return changes
+}
+
+// Fait avancer les alertes sans nouvel instantané : une alerte en attente
+// se déclenche une fois sa durée for écoulée si l'hôte envoie toujours
+// (en retard, elle attend le prochain instantané), et celles d'un hôte hors
+// ligne, dont les mesures ne sont plus fiables, sont levées
+func (e *AlertEngine) Tick(now time.Time, status func(hostname string) string) []AlertChange {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	durations := make(map[string]time.Duration, len(e.rules))
+	for _, rule := range e.rules {
+		durations[rule.Name] = time.Duration(rule.For)
+	}
+	var changes []AlertChange
+	for key, alert := range e.alerts {
+		if alert.State == AlertResolved {
+			continue
+		}
+		hostStatus := status(alert.Hostname)
+		if hostStatus == StatusOffline {
+			if change, ok := e.clear(key, alert, now); ok {
+				changes = append(changes, change)
+			}
+			continue
+		}
+		forDuration, known := durations[alert.Rule]
+		if alert.State == AlertPending && known && hostStatus == StatusOnline && now.Sub(alert.ActiveSince) >= forDuration {
+			fired := now
+			alert.State = AlertFiring
+			alert.FiredAt = &fired
+			alert.UpdatedAt = now
+			changes = append(changes, AlertChange{Alert: *alert, From: AlertPending})
+		}
+	}
+	e.purgeResolved(now)
+	return changes
+}
+
+// Lève une alerte : oubliée si elle était en attente, résolue si elle
+// était déclenchée (appelé sous e.mu)
+func (e *AlertEngine) clear(key string, alert *Alert, now time.Time) (AlertChange, bool) {
+	if alert.State == AlertPending {
+		delete(e.alerts, key)
+		return AlertChange{}, false
+	}
+	resolved := now
+	alert.State = AlertResolved
+	alert.ResolvedAt = &resolved
+	alert.UpdatedAt = now
+	return AlertChange{Alert: *alert, From: AlertFiring}, true
+}
+
+// Purge des alertes résolues depuis longtemps (appelé sous e.mu)
+func (e *AlertEngine) purgeResolved(now time.Time) {
+	for key, alert := range e.alerts {
+		if alert.State == AlertResolved && now.Sub(*alert.ResolvedAt) > resolvedRetention {
+			delete(e.alerts, key)
+		}
+	}
+}
+
+// Alertes en cours (pending et firing) ; avec resolved, aussi les résolues récentes
+func (e *AlertEngine) List(includeResolved bool) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := []Alert{}
+	for _, alert := range e.alerts {
+		if alert.State == AlertResolved && !includeResolved {
+			continue
+		}
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Hostname != alerts[j].Hostname {
+			return alerts[i].Hostname < alerts[j].Hostname
+		}
+		return alerts[i].ActiveSince.Before(alerts[j].ActiveSince)
+	})
+	return alerts
+}
+
+func (e *AlertEngine) Rules() []AlertRule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]AlertRule(nil), e.rules...)
+}
+
+// Oublie les alertes d'un hôte supprimé
+func (e *AlertEngine) Forget(hostname string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, alert := range e.alerts {
+		if alert.Hostname == hostname {
+			delete(e.alerts, key)
+		}
+	}
+}
+
+func logAlertChange(change AlertChange) {
+	a := change.Alert
+	switch a.State {
+	case AlertFiring:
+		fmt.Printf("🚨 Alerte %s déclenchée: %s\n", a.Rule, a.Summary)
+	case AlertResolved:
+		fmt.Printf("✅ Alerte %s résolue sur %s\n", a.Rule, a.Hostname)
+	}
+}
+
+// Journalise, diffuse et notifie les changements d'état des alertes
+func publishAlertChanges(changes []AlertChange) {
+	for _, change := range changes {
+		logAlertChange(change)
+		events.Publish(Event{Type: EventAlert, Hostname: change.Alert.Hostname, Data: change})
+	}
+	notifier.Notify(changes)
+}
+
+// Réévalue périodiquement les alertes, y compris celles des hôtes qui
+// n'envoient plus rien
+func startAlertEvaluator(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			publishAlertChanges(alerts.Tick(now.UTC(), func(hostname string) string {
+				status, _ := liveness.Status(hostname)
+				return status
+			}))
+		}
+	}()
+}
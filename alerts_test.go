@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sans nouvel envoi, une alerte en attente se déclenche une fois sa durée
+// for écoulée, puis se résout quand l'hôte passe hors ligne
+func TestAlertEngineTick(t *testing.T) {
+	engine := NewAlertEngine([]AlertRule{{Name: "cpu", Kind: RuleHostCPU, Threshold: 90, For: ruleDuration(time.Minute)}})
+	start := time.Now().UTC()
+	hostStatus := StatusOnline
+	status := func(string) string { return hostStatus }
+
+	changes := engine.Evaluate(testSnapshot("web", start, 95), start)
+	if len(changes) != 1 || changes[0].Alert.State != AlertPending {
+		t.Fatalf("Evaluate = %+v, attendu une alerte pending", changes)
+	}
+	if changes := engine.Tick(start.Add(30*time.Second), status); len(changes) != 0 {
+		t.Fatalf("Tick avant la durée for = %+v", changes)
+	}
+	changes = engine.Tick(start.Add(time.Minute), status)
+	if len(changes) != 1 || changes[0].From != AlertPending || changes[0].Alert.State != AlertFiring {
+		t.Fatalf("Tick après la durée for = %+v, attendu pending → firing", changes)
+	}
+
+	hostStatus = StatusOffline
+	changes = engine.Tick(start.Add(2*time.Minute), status)
+	if len(changes) != 1 || changes[0].From != AlertFiring || changes[0].Alert.State != AlertResolved {
+		t.Fatalf("Tick hôte hors ligne = %+v, attendu firing → resolved", changes)
+	}
+	if active := engine.List(false); len(active) != 0 {
+		t.Errorf("alertes encore actives: %+v", active)
+	}
+}
+
+// Une alerte en attente d'un hôte hors ligne disparaît sans notification
+func TestAlertEngineTickOfflinePending(t *testing.T) {
+	engine := NewAlertEngine([]AlertRule{{Name: "cpu", Kind: RuleHostCPU, Threshold: 90, For: ruleDuration(time.Hour)}})
+	start := time.Now().UTC()
+	engine.Evaluate(testSnapshot("web", start, 95), start)
+	if changes := engine.Tick(start.Add(time.Minute), func(string) string { return StatusOffline }); len(changes) != 0 {
+		t.Fatalf("Tick = %+v, attendu aucun changement", changes)
+	}
+	if all := engine.List(true); len(all) != 0 {
+		t.Errorf("alerte en attente conservée: %+v", all)
+	}
+}
+
+// Une alerte en attente d'un hôte en retard ne se déclenche pas sans nouvel
+// instantané ; le suivant, toujours au-dessus du seuil, la déclenche
+func TestAlertEngineTickStalePending(t *testing.T) {
+	engine := NewAlertEngine([]AlertRule{{Name: "cpu", Kind: RuleHostCPU, Threshold: 90, For: ruleDuration(time.Minute)}})
+	start := time.Now().UTC()
+	engine.Evaluate(testSnapshot("web", start, 95), start)
+	if changes := engine.Tick(start.Add(2*time.Minute), func(string) string { return StatusStale }); len(changes) != 0 {
+		t.Fatalf("Tick hôte en retard = %+v, attendu aucun changement", changes)
+	}
+	if active := engine.List(false); len(active) != 1 || active[0].State != AlertPending {
+		t.Fatalf("alertes = %+v, attendu une alerte pending", active)
+	}
+	now := start.Add(3 * time.Minute)
+	changes := engine.Evaluate(testSnapshot("web", now, 96), now)
+	if len(changes) != 1 || changes[0].Alert.State != AlertFiring {
+		t.Fatalf("Evaluate = %+v, attendu pending → firing", changes)
+	}
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr string
+	}{
+		{"valides", `{"name":"cpu","kind":"host_cpu","threshold":90,"for":"1m"},{"name":"core","kind":"core_cpu","threshold":95,"for":"1m"}`, ""},
+		{"nom en double", `{"name":"cpu","kind":"host_cpu","threshold":90,"for":"1m"},{"name":"cpu","kind":"core_cpu","threshold":95,"for":"1m"}`, "déjà utilisé"},
+		{"nom absent", `{"kind":"host_cpu","threshold":90,"for":"1m"}`, "name requis"},
+		{"kind inconnu", `{"name":"cpu","kind":"disk","threshold":90,"for":"1m"}`, "kind inconnu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "alerts.json")
+			if err := os.WriteFile(path, []byte(`{"rules":[`+tt.rules+`]}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadAlertRules(path)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("LoadAlertRules: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("LoadAlertRules = %v, attendu %q", err, tt.wantErr)
+			}
+		})
+	}
+}
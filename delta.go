@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Tenkydo/monprojet/protocol"
+)
+
+// Base d'un delta introuvable ou incohérente : l'agent doit renvoyer un
+// instantané complet
+var errResync = errors.New("base du delta inconnue, instantané complet requis")
+
+// Reconstruit la liste complète des processus d'un delta à partir du
+// dernier instantané enregistré, qui doit porter la séquence de base
+func resolveDelta(data *SystemData) error {
+	if !data.IsDelta() {
+		return nil
+	}
+	if data.ProcessDelta == nil || data.BaseSequence >= data.Sequence {
+		return fmt.Errorf("delta invalide: base %d, séquence %d", data.BaseSequence, data.Sequence)
+	}
+	prev, _, ok := registry.Get(data.Hostname)
+	if !ok || prev.Sequence != data.BaseSequence {
+		return fmt.Errorf("%w (base %d)", errResync, data.BaseSequence)
+	}
+	procs, err := protocol.ApplyDelta(prev.Processes, *data.ProcessDelta)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errResync, err)
+	}
+	counters.deltas.Add(1)
+	data.Processes = procs
+	data.ProcessDelta = nil
+	data.BaseSequence = 0
+	return nil
+}
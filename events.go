@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Taille du tampon de chaque abonné ; au-delà il est déconnecté
+const subscriberBuffer = 64
+
+// Période des commentaires de maintien de connexion
+const sseKeepAlive = 15 * time.Second
+
+// Types d'événements diffusés
+const (
+	EventHost     = "host"     // nouvel instantané d'un hôte
+	EventLiveness = "liveness" // transition online/stale/offline
+	EventAlert    = "alert"    // changement d'état d'une alerte
+	EventDelete   = "delete"   // hôte supprimé
+)
+
+// Événement diffusé aux tableaux de bord
+type Event struct {
+	Type     string
+	Hostname string // hôte concerné, pour filtrer selon les droits de l'abonné
+	Data     interface{}
+}
+
+// Mise à jour d'un hôte
+type HostEvent struct {
+	Hostname string     `json:"hostname"`
+	Data     SystemData `json:"data"`
+	Meta     HostMeta   `json:"meta"`
+}
+
+type subscriber struct {
+	events chan Event
+}
+
+// Diffuseur d'événements : la publication ne bloque jamais, un abonné trop
+// lent est déconnecté plutôt que de ralentir l'ingestion
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[*subscriber]struct{})}
+}
+
+func (h *EventHub) Subscribe() *subscriber {
+	s := &subscriber{events: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *EventHub) Unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+func (h *EventHub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		select {
+		case s.events <- ev:
+		default:
+			delete(h.subs, s)
+			close(s.events)
+		}
+	}
+}
+
+// Nombre d'abonnés connectés
+func (h *EventHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Flux Server-Sent Events
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming non supporté")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	p := principalFrom(r)
+	sub := events.Subscribe()
+	defer events.Unsubscribe(sub)
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.events:
+			if !ok {
+				// abonné trop lent : le navigateur se reconnectera
+				return
+			}
+			if !p.CanSeeHost(ev.Hostname) {
+				continue
+			}
+			payload, err := json.Marshal(ev.Data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, payload)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+func TestEventHubPublish(t *testing.T) {
+	tests := []struct {
+		name      string
+		published int
+		wantOpen  bool // abonné toujours connecté
+	}{
+		{"aucun événement", 0, true},
+		{"dans le tampon", 3, true},
+		{"tampon plein", subscriberBuffer, true},
+		{"abonné trop lent", subscriberBuffer + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewEventHub()
+			sub := hub.Subscribe()
+			for i := 0; i < tt.published; i++ {
+				hub.Publish(Event{Type: EventHost, Data: i})
+			}
+			if got := hub.Count() == 1; got != tt.wantOpen {
+				t.Fatalf("abonné connecté = %v, attendu %v", got, tt.wantOpen)
+			}
+			// désabonner un abonné déjà déconnecté ne doit pas paniquer
+			hub.Unsubscribe(sub)
+			if n := hub.Count(); n != 0 {
+				t.Fatalf("Count après désabonnement = %d", n)
+			}
+			received := 0
+			for ev := range sub.events {
+				if ev.Data != received {
+					t.Fatalf("événement %d reçu à la place de %d", ev.Data, received)
+				}
+				received++
+			}
+			want := tt.published
+			if !tt.wantOpen {
+				want = subscriberBuffer
+			}
+			if received != want {
+				t.Errorf("%d événements reçus, attendu %d", received, want)
+			}
+		})
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	savedEvents, savedGroups, savedRegistry := events, hostGroups, registry
+	defer func() { events, hostGroups, registry = savedEvents, savedGroups, savedRegistry }()
+	events = NewEventHub()
+	web := &HostGroup{Name: "web", Hostnames: []string{"web-.*"}}
+	if err := web.compile(); err != nil {
+		t.Fatal(err)
+	}
+	hostGroups = map[string]*HostGroup{"web": web}
+	registry = NewRegistry()
+	for _, hostname := range []string{"web-1", "db-1"} {
+		registry.Update(SystemData{Hostname: hostname}, "192.0.2.1", time.Now())
+	}
+
+	// abonné limité au groupe web
+	viewer := Principal{Login: "bob", Role: user.RoleViewer, Groups: []string{"web"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleEvents(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, viewer)))
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	readEvent := func() string {
+		var block []string
+		for lines.Scan() {
+			if lines.Text() == "" {
+				return strings.Join(block, "\n")
+			}
+			block = append(block, lines.Text())
+		}
+		t.Fatalf("flux interrompu: %v", lines.Err())
+		return ""
+	}
+	// l'abonnement précède l'envoi du délai de reconnexion
+	if got := readEvent(); got != "retry: 3000" {
+		t.Fatalf("premier bloc = %q", got)
+	}
+
+	tests := []struct {
+		name string
+		ev   Event
+		want string // vide : événement non diffusé à cet abonné
+	}{
+		{"suppression", Event{Type: EventDelete, Hostname: "web-1", Data: map[string]string{"hostname": "web-1"}}, "event: delete\ndata: {\"hostname\":\"web-1\"}"},
+		{"hôte hors des groupes", Event{Type: EventHost, Hostname: "db-1", Data: HostEvent{Hostname: "db-1"}}, ""},
+		{"alerte", Event{Type: EventAlert, Hostname: "web-1", Data: map[string]interface{}{"state": "firing", "value": 97.5}}, "event: alert\ndata: {\"state\":\"firing\",\"value\":97.5}"},
+		{"données non sérialisables", Event{Type: EventHost, Hostname: "web-1", Data: make(chan int)}, ""},
+		{"hôte", Event{Type: EventHost, Hostname: "web-1", Data: HostEvent{Hostname: "web-1"}}, "event: host\ndata: {\"hostname\":\"web-1\","},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events.Publish(tt.ev)
+			if tt.want == "" {
+				return
+			}
+			if got := readEvent(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("bloc = %q, attendu %q", got, tt.want)
+			}
+		})
+	}
+}
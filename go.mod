@@ -2,12 +2,17 @@ module github.com/Tenkydo/monprojet
 
 go 1.25.0
 
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/shirou/gopsutil/v3 v3.24.5
+	golang.org/x/crypto v0.41.0
+)
+
 require (
 	github.com/ebitengine/purego v0.8.4 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
-	github.com/shirou/gopsutil/v3 v3.24.5 // indirect
 	github.com/shirou/gopsutil/v4 v4.25.8 // indirect
 	github.com/shoenig/go-m1cpu v0.1.6 // indirect
 	github.com/tklauser/go-sysconf v0.3.15 // indirect
@@ -1,13 +1,43 @@
 package main
 
 import (
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
-	"sort"
+	"strconv"
 	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
 )
 
+// Erreur JSON avec un message libre (échappé)
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// Corps de requête décompressé selon Content-Encoding
+func contentReader(r *http.Request, body io.Reader) (io.ReadCloser, error) {
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip invalide: %v", err)
+		}
+		// la limite s'applique aussi aux données décompressées
+		return http.MaxBytesReader(nil, gz, ingestLimits.MaxBody), nil
+	}
+	return nil, fmt.Errorf("Content-Encoding non supporté: %s", r.Header.Get("Content-Encoding"))
+}
+
 // Page principale
 func serveIndex(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "static2/index.html")
@@ -16,48 +46,289 @@ func serveIndex(w http.ResponseWriter, r *http.Request) {
 // Réception des données CPU + processus
 func handleCPU(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error":"Méthode non autorisée"}`, http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
 		return
 	}
 
+	// versions du schéma, formats et compressions acceptés, annoncés sur
+	// toutes les réponses pour que l'agent choisisse le plus compact
+	w.Header().Set(protocol.HeaderVersions, protocol.FormatVersions(protocol.SupportedVersions()))
+	w.Header().Set(protocol.HeaderAcceptPost, protocol.ContentTypeProtobuf+", "+protocol.ContentTypeJSON)
+	w.Header().Set(protocol.HeaderAcceptEncoding, "gzip")
+
+	counters.ingestRequests.Add(1)
+	if err := limiter.AllowGlobal(time.Now()); err != nil {
+		writeIngestError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	limitBody(w, r)
+	body := &countingReader{r: r.Body}
+	defer func() { counters.ingestBytes.Add(uint64(body.n)) }()
+
+	reader, err := contentReader(r, body)
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		writeJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	// protobuf si annoncé par Content-Type, JSON sinon ; les anciennes
+	// versions du schéma sont mises à niveau au décodage
 	var systemData SystemData
-	if err := json.NewDecoder(r.Body).Decode(&systemData); err != nil {
-		log.Printf("❌ Erreur décodage JSON: %v", err)
-		http.Error(w, `{"error":"Impossible de décoder le JSON"}`, http.StatusBadRequest)
+	decode := protocol.Unmarshal
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == protocol.ContentTypeProtobuf {
+		decode = protocol.UnmarshalBinary
+	}
+	if err := decode(raw, &systemData); err != nil {
+		counters.decodeErrors.Add(1)
+		log.Printf("❌ Erreur décodage: %v", err)
+		if errors.Is(err, protocol.ErrUnsupportedVersion) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":              err.Error(),
+				"supported_versions": protocol.SupportedVersions(),
+			})
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Impossible de décoder les données")
 		return
 	}
 
-	clientsData[systemData.Hostname] = systemData
-	saveSystemData(systemData)
-	logSystemData(systemData)
+	// delta de processus : identité vérifiée avant de consulter la base,
+	// base absente ou périmée => 409 et demande d'instantané complet
+	if systemData.IsDelta() {
+		if err := authorizeHost(r, systemData.Hostname); err != nil {
+			counters.authFailures.Add(1)
+			writeIngestError(w, http.StatusForbidden, err)
+			return
+		}
+		if err := resolveDelta(&systemData); errors.Is(err, errResync) {
+			counters.resyncs.Add(1)
+			log.Printf("🔄 Resynchronisation demandée à %s: %v", systemData.Hostname, err)
+			w.Header().Set(protocol.HeaderResync, "1")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "resync": true})
+			return
+		} else if err != nil {
+			counters.decodeErrors.Add(1)
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if status, err := checkSnapshots(r, []SystemData{systemData}, time.Now()); err != nil {
+		writeIngestError(w, status, err)
+		return
+	}
+	if err := ingestQueue.Do(func() error { return ingestSnapshot(systemData, sourceIP(r)) }); err != nil {
+		if status := throttleStatus(err, 0); status != 0 {
+			writeIngestError(w, status, err)
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "données non enregistrées")
+		return
+	}
 
+	// séquence acquittée : base des deltas suivants de l'agent
+	if systemData.Sequence != 0 {
+		w.Header().Set(protocol.HeaderAck, strconv.FormatUint(systemData.Sequence, 10))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":            "ok",
-		"hostname":          systemData.Hostname,
-		"cores_received":    len(systemData.CoreData),
+		"status":             "ok",
+		"schema_version":     systemData.SchemaVersion,
+		"hostname":           systemData.Hostname,
+		"cores_received":     len(systemData.CoreData),
 		"processes_received": len(systemData.Processes),
-		"timestamp":         time.Now().Format(time.RFC3339),
+		"timestamp":          time.Now().Format(time.RFC3339),
 	})
 }
 
+// Chemin commun à tous les formats d'ingestion : persistance, registre,
+// fraîcheur, alertes puis diffusion
+func ingestSnapshot(systemData SystemData, source string) error {
+	// stocké dans la version courante, quel que soit le format reçu
+	systemData.SchemaVersion = protocol.CurrentVersion
+	// sans collected_at, horodaté une fois à la réception : la valeur
+	// stockée fait ensuite foi partout (journal, stockage, fraîcheur)
+	now := time.Now().UTC()
+	if _, err := time.Parse(time.RFC3339, systemData.CollectedAt); err != nil {
+		systemData.CollectedAt = now.Format(time.RFC3339Nano)
+	}
+	if err := persistSnapshot(systemData); err != nil {
+		counters.storeErrors.Add(1)
+		log.Printf("❌ Erreur persistance: %v", err)
+		return err
+	}
+
+	registry.Update(systemData, source, now)
+	if t, changed := liveness.Observe(systemData.Hostname, snapshotTime(systemData), now); changed {
+		logTransition(t)
+		events.Publish(Event{Type: EventLiveness, Hostname: t.Hostname, Data: t})
+	}
+	publishAlertChanges(alerts.Evaluate(systemData, now))
+	publishHost(systemData.Hostname)
+	logSystemData(systemData)
+	return nil
+}
+
+// Ingestion d'un lot (line protocol, OTLP), arrêtée à la première erreur
+func ingestBatch(snapshots []SystemData, source string) error {
+	for _, systemData := range snapshots {
+		if err := ingestSnapshot(systemData, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diffuse le dernier état d'un hôte aux abonnés
+func publishHost(hostname string) {
+	if events.Count() == 0 {
+		return
+	}
+	data, meta, ok := registry.Get(hostname)
+	if !ok {
+		return
+	}
+	status, interval := liveness.Status(hostname)
+	meta.Status = status
+	meta.ExpectedInterval = interval.Seconds()
+	events.Publish(Event{Type: EventHost, Hostname: hostname, Data: HostEvent{Hostname: hostname, Data: data, Meta: meta}})
+}
+
 // API clients
 func handleClients(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	if r.Method == http.MethodDelete {
+		handleDeleteClient(w, r)
+		return
+	}
+
+	clients, hosts := visibleClients(r)
 	webData := WebData{
-		Clients:    clientsData,
+		Clients:    clients,
+		Hosts:      hosts,
 		LastUpdate: time.Now().Format(time.RFC3339),
 	}
 	json.NewEncoder(w).Encode(webData)
 }
 
-// API stats
+// Suppression d'un hôte (état courant et données stockées)
+func handleDeleteClient(w http.ResponseWriter, r *http.Request) {
+	hostname := r.URL.Query().Get("hostname")
+	if hostname == "" {
+		writeJSONError(w, http.StatusBadRequest, "hostname requis")
+		return
+	}
+	if !principalFrom(r).CanSeeHost(hostname) {
+		writeJSONError(w, http.StatusNotFound, "client non trouvé")
+		return
+	}
+	if err := store.DeleteHost(hostname); err != nil {
+		log.Printf("❌ Erreur suppression %s: %v", hostname, err)
+		writeJSONError(w, http.StatusInternalServerError, "suppression impossible")
+		return
+	}
+	registry.Delete(hostname)
+	liveness.Forget(hostname)
+	alerts.Forget(hostname)
+	events.Publish(Event{Type: EventDelete, Hostname: hostname, Data: map[string]string{"hostname": hostname}})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "deleted",
+		"hostname": hostname,
+	})
+}
+
+// API fraîcheur : transitions d'état (d'un hôte si hostname= est donné)
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	hostname := r.URL.Query().Get("hostname")
+	p := principalFrom(r)
+	transitions := []LivenessTransition{}
+	for _, t := range liveness.Transitions(hostname) {
+		if p.CanSeeHost(t.Hostname) {
+			transitions = append(transitions, t)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hostname":    hostname,
+		"transitions": transitions,
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// API alertes : en cours, ou aussi les résolues récentes avec state=all
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	p := principalFrom(r)
+	list := []Alert{}
+	for _, alert := range alerts.List(r.URL.Query().Get("state") == "all") {
+		if p.CanSeeHost(alert.Hostname) {
+			list = append(list, alert)
+		}
+	}
+	firing := 0
+	for _, alert := range list {
+		if alert.State == AlertFiring {
+			firing++
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alerts":    list,
+		"count":     len(list),
+		"firing":    firing,
+		"rules":     alerts.Rules(),
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// API stats ; window= (ex. "1h") calcule sur l'historique plutôt que sur le dernier instantané
 func handleStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	stats := computeStats(clientsData)
+
+	now := time.Now().UTC()
+	clients, metas := visibleClients(r)
+
+	var samples map[string][]cpuSample
+	window := r.URL.Query().Get("window")
+	if window != "" {
+		d, err := parseStepParam(window, 0)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "paramètre window invalide")
+			return
+		}
+		// pas du palier d'agrégats qui couvre toute la fenêtre
+		step := stepForWindow(retention.Tiers, d)
+		samples = make(map[string][]cpuSample, len(clients))
+		for hostname := range clients {
+			hostSamples, err := store.History(hostname, now.Add(-d), now, step)
+			if err != nil {
+				log.Printf("❌ Erreur lecture historique %s: %v", hostname, err)
+				writeJSONError(w, http.StatusInternalServerError, "lecture de l'historique impossible")
+				return
+			}
+			samples[hostname] = hostSamples
+		}
+	}
+
+	stats := computeStats(clients, metas, samples, now)
+	stats.Window = window
 	json.NewEncoder(w).Encode(stats)
 }
 
@@ -68,26 +339,109 @@ func handleProcesses(w http.ResponseWriter, r *http.Request) {
 
 	hostname := r.URL.Query().Get("hostname")
 	if hostname == "" {
-		http.Error(w, `{"error":"hostname requis"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "hostname requis")
 		return
 	}
 
-	systemData, exists := clientsData[hostname]
-	if !exists {
-		http.Error(w, `{"error":"client non trouvé"}`, http.StatusNotFound)
+	systemData, _, exists := registry.Get(hostname)
+	if !exists || !principalFrom(r).CanSee(systemData) {
+		writeJSONError(w, http.StatusNotFound, "client non trouvé")
 		return
 	}
 
-	processes := make([]ProcessInfo, len(systemData.Processes))
-	copy(processes, systemData.Processes)
-	sort.Slice(processes, func(i, j int) bool {
-		return processes[i].CPUPercent > processes[j].CPUPercent
-	})
+	query, err := parseProcessQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	processes := query.Apply(systemData.Processes)
+	if query.Stats {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"hostname":  hostname,
+			"stats":     computeProcessStats(processes),
+			"timestamp": systemData.CollectedAt,
+		})
+		return
+	}
+
+	page := query.Page(processes)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"hostname":  hostname,
-		"processes": processes,
-		"count":     len(processes),
+		"processes": page,
+		"count":     len(page),
+		"total":     len(processes),
+		"offset":    query.Offset,
+		"limit":     query.Limit,
 		"timestamp": systemData.CollectedAt,
 	})
 }
+
+// Recherche de processus sur l'ensemble des hôtes
+func handleProcessSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, err := parseProcessQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	clients, metas := visibleClients(r)
+	results := searchProcesses(clients, metas, query)
+	matches := 0
+	for _, hostMatches := range results {
+		matches += hostMatches.Total
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":   results,
+		"hosts":     len(results),
+		"matches":   matches,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// API historique
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := r.URL.Query()
+	hostname := query.Get("hostname")
+	if hostname == "" {
+		writeJSONError(w, http.StatusBadRequest, "hostname requis")
+		return
+	}
+	if !principalFrom(r).CanSeeHost(hostname) {
+		writeJSONError(w, http.StatusNotFound, "client non trouvé")
+		return
+	}
+
+	now := time.Now().UTC()
+	to, err := parseTimeParam(query.Get("to"), now)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "paramètre to invalide")
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-time.Hour))
+	if err != nil || from.After(to) {
+		writeJSONError(w, http.StatusBadRequest, "paramètre from invalide")
+		return
+	}
+	step, err := parseStepParam(query.Get("step"), time.Minute)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "paramètre step invalide")
+		return
+	}
+
+	samples, err := store.History(hostname, from, to, step)
+	if err != nil {
+		log.Printf("❌ Erreur lecture historique: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "lecture de l'historique impossible")
+		return
+	}
+
+	json.NewEncoder(w).Encode(buildHistorySeries(hostname, samples, from, to, step))
+}
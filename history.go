@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
+)
+
+// Durée couverte par un segment append-only
+const segmentSpan = time.Hour
+
+// Un instantané sans date ne peut pas être rangé dans l'historique
+var errNoTimestamp = errors.New("instantané sans horodatage (collected_at)")
+
+// Point d'une série temporelle
+type HistoryPoint struct {
+	Timestamp time.Time `json:"t"`
+	Value     float64   `json:"v"`
+}
+
+// Séries CPU d'un hôte sur une période
+type HistorySeries struct {
+	Hostname string                 `json:"hostname"`
+	From     time.Time              `json:"from"`
+	To       time.Time              `json:"to"`
+	Step     int64                  `json:"step"`
+	Cores    map[int][]HistoryPoint `json:"cores"`
+	Average  []HistoryPoint         `json:"average"`
+}
+
+// Stockage historique : un répertoire par hôte, un segment JSON lines par heure
+type HistoryStore struct {
+	mu  sync.Mutex
+	dir string
+	// index temporel : débuts de segments triés, par hôte
+	index map[string][]time.Time
+	// segment ouvert en écriture, par hôte
+	open map[string]*openSegment
+	// politique de rétention (voir retention.go)
+	retention RetentionConfig
+}
+
+type openSegment struct {
+	start time.Time
+	file  *os.File
+}
+
+// Ouvre le stockage et reconstruit l'index à partir des segments présents ;
+// seuls les répertoires contenant des segments ou des agrégats sont des hôtes
+// (notify/ et autres répertoires de service sont ignorés)
+func OpenHistoryStore(dir string) (*HistoryStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	h := &HistoryStore{
+		dir:   dir,
+		index: make(map[string][]time.Time),
+		open:  make(map[string]*openSegment),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		segments, err := os.ReadDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		starts := []time.Time{}
+		rollups := false
+		for _, seg := range segments {
+			if start, ok := parseSegmentName(seg.Name()); ok {
+				starts = append(starts, start)
+			} else if _, _, ok := parseRollupFileName(seg.Name()); ok {
+				rollups = true
+			}
+		}
+		if len(starts) == 0 && !rollups {
+			continue
+		}
+		sortTimes(starts)
+		h.index[hostFromKey(entry.Name())] = starts
+	}
+	return h, nil
+}
+
+// Ajoute un instantané au segment courant de l'hôte
+func (h *HistoryStore) Append(data SystemData) error {
+	ts := snapshotTime(data)
+	if ts.IsZero() {
+		return errNoTimestamp
+	}
+	start := ts.Truncate(segmentSpan)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seg, err := h.segmentFor(data.Hostname, start)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = seg.file.Write(line)
+	return err
+}
+
+// Retourne (et ouvre si besoin) le segment d'un hôte pour une heure donnée
+func (h *HistoryStore) segmentFor(hostname string, start time.Time) (*openSegment, error) {
+	if seg, ok := h.open[hostname]; ok {
+		if seg.start.Equal(start) {
+			return seg, nil
+		}
+		seg.file.Sync()
+		seg.file.Close()
+		delete(h.open, hostname)
+	}
+
+	hostDir := h.hostDir(hostname)
+	if err := os.MkdirAll(hostDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(hostDir, segmentName(start))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := terminatePartialLine(path, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	seg := &openSegment{start: start, file: file}
+	h.open[hostname] = seg
+	h.addToIndex(hostname, start)
+	return seg, nil
+}
+
+func (h *HistoryStore) addToIndex(hostname string, start time.Time) {
+	starts := h.index[hostname]
+	i := sort.Search(len(starts), func(i int) bool { return !starts[i].Before(start) })
+	if i < len(starts) && starts[i].Equal(start) {
+		return
+	}
+	starts = append(starts, time.Time{})
+	copy(starts[i+1:], starts[i:])
+	starts[i] = start
+	h.index[hostname] = starts
+}
+
+// Lit les instantanés d'un hôte compris dans [from, to]
+func (h *HistoryStore) Query(hostname string, from, to time.Time) ([]SystemData, error) {
+	h.mu.Lock()
+	var paths []string
+	for _, start := range h.index[hostname] {
+		if start.After(to) || !start.Add(segmentSpan).After(from) {
+			continue
+		}
+		paths = append(paths, filepath.Join(h.hostDir(hostname), segmentName(start)))
+	}
+	h.mu.Unlock()
+
+	var result []SystemData
+	for _, path := range paths {
+		err := readSegment(path, func(data SystemData) {
+			ts := snapshotTime(data)
+			if !ts.Before(from) && !ts.After(to) {
+				result = append(result, data)
+			}
+		}, logBadLine(path))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Indique si un instantané identique est déjà stocké dans son segment
+func (h *HistoryStore) Contains(data SystemData) (bool, error) {
+	ts := snapshotTime(data)
+	path := filepath.Join(h.hostDir(data.Hostname), segmentName(ts.Truncate(segmentSpan)))
+	found := false
+	err := readSegment(path, func(stored SystemData) {
+		if sameSnapshot(stored, data) {
+			found = true
+		}
+	}, func(int, error) {})
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return found, err
+}
+
+// Force l'écriture sur disque des segments ouverts
+func (h *HistoryStore) Sync() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, seg := range h.open {
+		if err := seg.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Liste les hôtes présents dans l'historique
+func (h *HistoryStore) Hosts() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hosts := make([]string, 0, len(h.index))
+	for hostname := range h.index {
+		hosts = append(hosts, hostname)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Ferme les segments ouverts
+func (h *HistoryStore) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for hostname, seg := range h.open {
+		seg.file.Sync()
+		seg.file.Close()
+		delete(h.open, hostname)
+	}
+	return nil
+}
+
+// Segments d'un hôte, du plus récent au plus ancien
+func (h *HistoryStore) segmentsNewestFirst(hostname string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	starts := h.index[hostname]
+	paths := make([]string, 0, len(starts))
+	for i := len(starts) - 1; i >= 0; i-- {
+		paths = append(paths, filepath.Join(h.hostDir(hostname), segmentName(starts[i])))
+	}
+	return paths
+}
+
+// Parcourt un segment ligne par ligne ; une ligne illisible est passée à bad puis ignorée
+func readSegment(path string, fn func(SystemData), bad func(line int, err error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var data SystemData
+		if err := protocol.Unmarshal(scanner.Bytes(), &data); err != nil {
+			bad(line, err)
+			continue
+		}
+		fn(data)
+	}
+	return scanner.Err()
+}
+
+// Après un arrêt brutal, la dernière ligne peut être tronquée : on la termine
+// pour que l'ajout suivant ne soit pas collé à elle
+func terminatePartialLine(path string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	last := make([]byte, 1)
+	if _, err := reader.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+func logBadLine(path string) func(int, error) {
+	return func(line int, err error) {
+		log.Printf("⚠️  Ligne %d illisible dans %s: %v", line, path, err)
+	}
+}
+
+// Échantillon CPU : valeur par cœur et moyenne des cœurs à un instant
+type cpuSample struct {
+	ts    time.Time
+	cores map[int]float64
+	avg   float64
+}
+
+// Convertit des instantanés bruts en échantillons CPU
+func samplesFromSnapshots(snapshots []SystemData) []cpuSample {
+	samples := make([]cpuSample, 0, len(snapshots))
+	for _, data := range snapshots {
+		if len(data.CoreData) == 0 {
+			continue
+		}
+		sample := cpuSample{ts: snapshotTime(data), cores: make(map[int]float64, len(data.CoreData))}
+		total := 0.0
+		for _, core := range data.CoreData {
+			sample.cores[core.Core] = core.CPUPercent
+			total += core.CPUPercent
+		}
+		sample.avg = total / float64(len(data.CoreData))
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// Construit les séries par cœur et moyenne, agrégées par pas de temps
+func buildHistorySeries(hostname string, samples []cpuSample, from, to time.Time, step time.Duration) HistorySeries {
+	type bucket struct {
+		sum   float64
+		count int
+	}
+	cores := make(map[int]map[int64]*bucket)
+	avg := make(map[int64]*bucket)
+
+	add := func(m map[int64]*bucket, key int64, v float64) {
+		b, ok := m[key]
+		if !ok {
+			b = &bucket{}
+			m[key] = b
+		}
+		b.sum += v
+		b.count++
+	}
+
+	for _, sample := range samples {
+		key := sample.ts.Truncate(step).Unix()
+		for core, value := range sample.cores {
+			if cores[core] == nil {
+				cores[core] = make(map[int64]*bucket)
+			}
+			add(cores[core], key, value)
+		}
+		add(avg, key, sample.avg)
+	}
+
+	toPoints := func(m map[int64]*bucket) []HistoryPoint {
+		points := make([]HistoryPoint, 0, len(m))
+		for key, b := range m {
+			points = append(points, HistoryPoint{
+				Timestamp: time.Unix(key, 0).UTC(),
+				Value:     b.sum / float64(b.count),
+			})
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp.Before(points[j].Timestamp)
+		})
+		return points
+	}
+
+	series := HistorySeries{
+		Hostname: hostname,
+		From:     from,
+		To:       to,
+		Step:     int64(step / time.Second),
+		Cores:    make(map[int][]HistoryPoint, len(cores)),
+		Average:  toPoints(avg),
+	}
+	for core, buckets := range cores {
+		series.Cores[core] = toPoints(buckets)
+	}
+	return series
+}
+
+// Horodatage d'un instantané : collected_at, posé au plus tard à
+// l'ingestion ; zéro s'il est absent ou illisible (refusé par les stockages)
+func snapshotTime(data SystemData) time.Time {
+	if ts, err := time.Parse(time.RFC3339, data.CollectedAt); err == nil {
+		return ts.UTC()
+	}
+	return time.Time{}
+}
+
+// Accepte un horodatage RFC3339 ou un nombre de secondes Unix
+func parseTimeParam(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("horodatage invalide: %q", value)
+	}
+	return ts.UTC(), nil
+}
+
+// Accepte une durée Go ("5m") ou un nombre de secondes
+func parseStepParam(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("pas invalide: %q", value)
+	}
+	return d, nil
+}
+
+// Nom de répertoire sûr pour un hôte : hors [A-Za-z0-9._-], chaque octet est
+// encodé en %XX, ainsi qu'un point initial ; un nom valide reste inchangé
+func storageKey(hostname string) string {
+	var b strings.Builder
+	for i := 0; i < len(hostname); i++ {
+		c := hostname[i]
+		safe := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '_' || c == '-' || (c == '.' && i > 0)
+		if safe {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	if b.Len() == 0 {
+		return "%00"
+	}
+	return b.String()
+}
+
+// Inverse de storageKey
+func hostFromKey(key string) string {
+	if hostname, err := url.PathUnescape(key); err == nil {
+		return hostname
+	}
+	return key
+}
+
+func (h *HistoryStore) hostDir(hostname string) string {
+	return filepath.Join(h.dir, storageKey(hostname))
+}
+
+func segmentName(start time.Time) string {
+	return fmt.Sprintf("seg_%d.jsonl", start.Unix())
+}
+
+func parseSegmentName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, "seg_") || !strings.HasSuffix(name, ".jsonl") {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, "seg_"), ".jsonl"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0).UTC(), true
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// File d'ingestion bornée : un nombre fixe de workers écrit les instantanés ;
+// quand la file est pleine, l'envoi est refusé au lieu d'attendre
+type IngestQueue struct {
+	jobs chan ingestJob
+}
+
+type ingestJob struct {
+	run  func() error
+	done chan error
+}
+
+func NewIngestQueue(workers, size int) *IngestQueue {
+	q := &IngestQueue{jobs: make(chan ingestJob, size)}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *IngestQueue) work() {
+	for job := range q.jobs {
+		job.done <- job.run()
+	}
+}
+
+// Exécute fn sur un worker et attend son résultat ; file pleine : 503
+func (q *IngestQueue) Do(fn func() error) error {
+	if q == nil {
+		return fn()
+	}
+	job := ingestJob{run: fn, done: make(chan error, 1)}
+	select {
+	case q.jobs <- job:
+	default:
+		counters.shed.Add(1)
+		return &throttleError{status: http.StatusServiceUnavailable, retryAfter: time.Second, reason: "file d'ingestion pleine"}
+	}
+	return <-job.done
+}
+
+// Envois en attente
+func (q *IngestQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.jobs)
+}
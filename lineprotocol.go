@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Taille maximale d'une ligne du protocole Influx
+const maxLineLength = 1024 * 1024
+
+// Point du protocole de ligne InfluxDB
+type linePoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{} // float64, int64, uint64, bool ou string
+	Time        time.Time              // zéro si absent
+}
+
+// Analyse une ligne : mesure[,tag=v...] champ=v[,champ=v...] [horodatage]
+func parseLine(line string, precision time.Duration) (linePoint, error) {
+	p := linePoint{Tags: make(map[string]string), Fields: make(map[string]interface{})}
+
+	key, rest, err := splitUnescaped(line, ' ', false)
+	if err != nil {
+		return p, err
+	}
+	parts, err := splitAll(key, ',')
+	if err != nil {
+		return p, err
+	}
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("mesure manquante")
+	}
+	for _, tag := range parts[1:] {
+		k, v, ok := cutUnescaped(tag, '=')
+		if !ok || k == "" || v == "" {
+			return p, fmt.Errorf("tag invalide: %q", tag)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	fieldSet, stamp, err := splitUnescaped(rest, ' ', true)
+	if err != nil {
+		return p, err
+	}
+	if fieldSet == "" {
+		return p, fmt.Errorf("aucun champ")
+	}
+	fields, err := splitAll(fieldSet, ',')
+	if err != nil {
+		return p, err
+	}
+	for _, field := range fields {
+		k, v, ok := cutUnescaped(field, '=')
+		if !ok || k == "" {
+			return p, fmt.Errorf("champ invalide: %q", field)
+		}
+		value, err := parseFieldValue(v)
+		if err != nil {
+			return p, fmt.Errorf("champ %s: %v", unescape(k), err)
+		}
+		p.Fields[unescape(k)] = value
+	}
+
+	if stamp = strings.TrimSpace(stamp); stamp != "" {
+		n, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("horodatage invalide: %q", stamp)
+		}
+		p.Time = time.Unix(0, n*int64(precision)).UTC()
+	}
+	return p, nil
+}
+
+func parseFieldValue(v string) (interface{}, error) {
+	switch {
+	case v == "":
+		return nil, fmt.Errorf("valeur vide")
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return nil, fmt.Errorf("chaîne non terminée")
+		}
+		s := v[1 : len(v)-1]
+		s = strings.ReplaceAll(s, `\"`, `"`)
+		return strings.ReplaceAll(s, `\\`, `\`), nil
+	case strings.HasSuffix(v, "i"):
+		return strconv.ParseInt(v[:len(v)-1], 10, 64)
+	case strings.HasSuffix(v, "u"):
+		return strconv.ParseUint(v[:len(v)-1], 10, 64)
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// Coupe s au premier sep non échappé (et hors guillemets si quoted)
+func splitUnescaped(s string, sep byte, quoted bool) (string, string, error) {
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			return s[:i], s[i+1:], nil
+		}
+	}
+	if inQuote {
+		return "", "", fmt.Errorf("guillemet non fermé")
+	}
+	return s, "", nil
+}
+
+func splitAll(s string, sep byte) ([]string, error) {
+	var parts []string
+	for {
+		head, tail, err := splitUnescaped(s, sep, true)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, head)
+		if len(head) == len(s) {
+			return parts, nil
+		}
+		s = tail
+	}
+}
+
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	head, tail, _ := splitUnescaped(s, sep, false)
+	return head, tail, len(head) < len(s)
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func fieldFloat(fields map[string]interface{}, name string) (float64, bool) {
+	switch v := fields[name].(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func fieldString(fields map[string]interface{}, name string) string {
+	if s, ok := fields[name].(string); ok {
+		return s
+	}
+	return ""
+}
+
+// Regroupe les points Telegraf par hôte et construit un instantané par hôte.
+// Les mesures absentes du lot (cpu ou procstat) sont reprises de l'état connu.
+func snapshotsFromPoints(points []linePoint, now time.Time) []SystemData {
+	type hostBatch struct {
+		data         SystemData
+		cores        map[int]CPUClientCoreData
+		procs        map[int32]ProcessInfo
+		latest       time.Time
+		hasCPU       bool
+		hasProcesses bool
+	}
+	hosts := make(map[string]*hostBatch)
+
+	for _, p := range points {
+		if p.Measurement != "cpu" && p.Measurement != "procstat" {
+			continue
+		}
+		hostname := p.Tags["host"]
+		if hostname == "" {
+			continue
+		}
+		h, ok := hosts[hostname]
+		if !ok {
+			h = &hostBatch{cores: make(map[int]CPUClientCoreData), procs: make(map[int32]ProcessInfo)}
+			h.data.Hostname = hostname
+			hosts[hostname] = h
+		}
+		at := p.Time
+		if at.IsZero() {
+			at = now
+		}
+		if at.After(h.latest) {
+			h.latest = at
+		}
+
+		switch p.Measurement {
+		case "cpu":
+			// cpu=cpu0, cpu=cpu1... ; cpu-total est recalculé par le serveur
+			core, err := strconv.Atoi(strings.TrimPrefix(p.Tags["cpu"], "cpu"))
+			if err != nil {
+				continue
+			}
+			idle, ok := fieldFloat(p.Fields, "usage_idle")
+			if !ok {
+				continue
+			}
+			h.hasCPU = true
+			h.cores[core] = CPUClientCoreData{
+				Core:       core,
+				CPUPercent: 100 - idle,
+				Timestamp:  at.Format(time.RFC3339),
+			}
+		case "procstat":
+			pid, ok := fieldFloat(p.Fields, "pid")
+			if !ok {
+				n, err := strconv.Atoi(p.Tags["pid"])
+				if err != nil {
+					continue
+				}
+				pid = float64(n)
+			}
+			h.hasProcesses = true
+			proc := ProcessInfo{
+				PID:      int32(pid),
+				Name:     p.Tags["process_name"],
+				Username: p.Tags["user"],
+				CmdLine:  fieldString(p.Fields, "cmdline"),
+				Status:   p.Tags["status"],
+			}
+			if v, ok := fieldFloat(p.Fields, "cpu_usage"); ok {
+				proc.CPUPercent = v
+			}
+			if v, ok := fieldFloat(p.Fields, "memory_usage"); ok {
+				proc.MemPercent = float32(v)
+			}
+			if v, ok := fieldFloat(p.Fields, "num_threads"); ok {
+				proc.NumThreads = int32(v)
+			}
+			if v, ok := fieldFloat(p.Fields, "created_at"); ok {
+				proc.CreateTime = int64(v) / int64(time.Millisecond)
+			}
+			h.procs[proc.PID] = proc
+		}
+	}
+
+	var snapshots []SystemData
+	for _, h := range hosts {
+		data := h.data
+		data.CollectedAt = h.latest.Format(time.RFC3339)
+		data.UserAgent = "telegraf"
+		data.CoreData = sortedCores(h.cores)
+		data.Processes = sortedProcesses(h.procs)
+		snapshots = append(snapshots, mergeWithKnown(data, h.hasCPU, h.hasProcesses))
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Hostname < snapshots[j].Hostname })
+	return snapshots
+}
+
+func sortedCores(cores map[int]CPUClientCoreData) []CPUClientCoreData {
+	var result []CPUClientCoreData
+	for _, core := range cores {
+		result = append(result, core)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Core < result[j].Core })
+	return result
+}
+
+func sortedProcesses(procs map[int32]ProcessInfo) []ProcessInfo {
+	var result []ProcessInfo
+	for _, proc := range procs {
+		result = append(result, proc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].PID < result[j].PID })
+	return result
+}
+
+// Complète un instantané partiel (collecteurs tiers) avec l'état connu de
+// l'hôte : métadonnées, et cœurs ou processus absents du lot
+func mergeWithKnown(data SystemData, hasCPU, hasProcesses bool) SystemData {
+	previous, _, known := registry.Get(data.Hostname)
+	if !known {
+		return data
+	}
+	if previous.CPUInfo.VendorID != "" || data.CPUInfo == (CPUInfo{}) {
+		data.CPUInfo = previous.CPUInfo
+	}
+	if data.OS == "" {
+		data.OS = previous.OS
+	}
+	if data.Platform == "" {
+		data.Platform = previous.Platform
+	}
+	if !hasCPU {
+		data.CoreData = previous.CoreData
+	}
+	if !hasProcesses {
+		data.Processes = previous.Processes
+	}
+	return data
+}
+
+func parsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, fmt.Errorf("precision invalide: %q", s)
+}
+
+// Réception au format InfluxDB line protocol (Telegraf, sortie influxdb)
+func handleWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+		return
+	}
+	precision, err := parsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	counters.ingestRequests.Add(1)
+	if err := limiter.AllowGlobal(time.Now()); err != nil {
+		writeIngestError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	limitBody(w, r)
+	body := &countingReader{r: r.Body}
+	defer func() { counters.ingestBytes.Add(uint64(body.n)) }()
+
+	reader, err := contentReader(r, body)
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	// Le lot est rejeté en entier à la première ligne invalide
+	var points []linePoint
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := parseLine(line, precision)
+		if err != nil {
+			counters.decodeErrors.Add(1)
+			// une ligne tronquée par la limite de taille n'est pas une erreur de syntaxe
+			if readErr := scanner.Err(); readErr != nil {
+				writeJSONError(w, bodyErrorStatus(readErr), readErr.Error())
+				return
+			}
+			log.Printf("❌ Erreur line protocol ligne %d: %v", lineNo, err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ligne %d: %v", lineNo, err))
+			return
+		}
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		counters.decodeErrors.Add(1)
+		writeJSONError(w, bodyErrorStatus(err), fmt.Sprintf("ligne %d: %v", lineNo+1, err))
+		return
+	}
+
+	now := time.Now().UTC()
+	snapshots := snapshotsFromPoints(points, now)
+	if status, err := checkSnapshots(r, snapshots, now); err != nil {
+		writeIngestError(w, status, err)
+		return
+	}
+	if err := ingestQueue.Do(func() error { return ingestBatch(snapshots, sourceIP(r)) }); err != nil {
+		writeIngestError(w, throttleStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
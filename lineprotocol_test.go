@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      linePoint
+		wantErr   bool
+	}{
+		{"tags et champs", `cpu,cpu=cpu0,host=web-1 usage_idle=87.5,usage_user=10 1700000000000000000`, time.Nanosecond,
+			linePoint{Measurement: "cpu", Tags: map[string]string{"cpu": "cpu0", "host": "web-1"},
+				Fields: map[string]interface{}{"usage_idle": 87.5, "usage_user": 10.0}, Time: time.Unix(1700000000, 0).UTC()}, false},
+		{"types de champs", `procstat pid=42i,threads=7u,running=t,stopped=FALSE,cmdline="nginx -g"`, time.Nanosecond,
+			linePoint{Measurement: "procstat", Tags: map[string]string{},
+				Fields: map[string]interface{}{"pid": int64(42), "threads": uint64(7), "running": true, "stopped": false, "cmdline": "nginx -g"}}, false},
+		{"échappements", `my\ cpu,host=web\,1,dc=eu\=west fi\=eld="a \"b\" \\ c, d=e"`, time.Nanosecond,
+			linePoint{Measurement: "my cpu", Tags: map[string]string{"host": "web,1", "dc": "eu=west"},
+				Fields: map[string]interface{}{"fi=eld": `a "b" \ c, d=e`}}, false},
+		{"précision seconde", `cpu,host=a usage_idle=1 1700000000`, time.Second,
+			linePoint{Measurement: "cpu", Tags: map[string]string{"host": "a"},
+				Fields: map[string]interface{}{"usage_idle": 1.0}, Time: time.Unix(1700000000, 0).UTC()}, false},
+		{"précision milliseconde", `cpu,host=a usage_idle=1 1700000000123`, time.Millisecond,
+			linePoint{Measurement: "cpu", Tags: map[string]string{"host": "a"},
+				Fields: map[string]interface{}{"usage_idle": 1.0}, Time: time.Unix(1700000000, 123e6).UTC()}, false},
+		{"mesure manquante", `,host=a usage_idle=1`, time.Nanosecond, linePoint{}, true},
+		{"aucun champ", `cpu,host=a`, time.Nanosecond, linePoint{}, true},
+		{"tag sans valeur", `cpu,host= usage_idle=1`, time.Nanosecond, linePoint{}, true},
+		{"champ sans valeur", `cpu usage_idle=`, time.Nanosecond, linePoint{}, true},
+		{"entier invalide", `cpu n=12xi`, time.Nanosecond, linePoint{}, true},
+		{"chaîne non terminée", `cpu s="abc`, time.Nanosecond, linePoint{}, true},
+		{"horodatage invalide", `cpu usage_idle=1 hier`, time.Nanosecond, linePoint{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line, tt.precision)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLine(%q): erreur %v, attendue: %v", tt.line, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLine(%q) =\n%+v\nattendu\n%+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", time.Nanosecond, false},
+		{"ns", time.Nanosecond, false},
+		{"u", time.Microsecond, false},
+		{"ms", time.Millisecond, false},
+		{"s", time.Second, false},
+		{"h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePrecision(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parsePrecision(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestSnapshotsFromPoints(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+	registry = NewRegistry()
+	registry.Update(SystemData{Hostname: "db-1", OS: "linux", CoreData: []CPUClientCoreData{{Core: 0, CPUPercent: 5}}}, "192.0.2.1", time.Now())
+
+	now := time.Date(2025, 9, 3, 12, 0, 0, 0, time.UTC)
+	at := now.Add(-time.Minute)
+	parse := func(lines ...string) []linePoint {
+		var points []linePoint
+		for _, line := range lines {
+			p, err := parseLine(line, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points = append(points, p)
+		}
+		return points
+	}
+	tests := []struct {
+		name      string
+		points    []linePoint
+		hostnames []string
+		check     func(t *testing.T, got []SystemData)
+	}{
+		{"cœurs à partir de usage_idle", parse(
+			"cpu,host=web-1,cpu=cpu1 usage_idle=75 1756900740",
+			"cpu,host=web-1,cpu=cpu0 usage_idle=90 1756900740",
+			"cpu,host=web-1,cpu=cpu-total usage_idle=82.5 1756900740",
+		), []string{"web-1"}, func(t *testing.T, got []SystemData) {
+			cores := got[0].CoreData
+			if len(cores) != 2 || cores[0].Core != 0 || cores[0].CPUPercent != 10 || cores[1].CPUPercent != 25 {
+				t.Errorf("cœurs = %+v", cores)
+			}
+			if collected, err := time.Parse(time.RFC3339, got[0].CollectedAt); err != nil || !collected.Equal(at) {
+				t.Errorf("CollectedAt = %q, attendu %s", got[0].CollectedAt, at)
+			}
+		}},
+		{"procstat", parse(
+			`procstat,host=web-1,process_name=nginx,user=www pid=42i,cpu_usage=3.5,memory_usage=1.25,num_threads=4i,cmdline="nginx -g" 1756900740`,
+			`procstat,host=web-1,process_name=sshd,pid=7 cpu_usage=0 1756900740`,
+		), []string{"web-1"}, func(t *testing.T, got []SystemData) {
+			procs := got[0].Processes
+			if len(procs) != 2 || procs[0].PID != 7 || procs[0].Name != "sshd" {
+				t.Fatalf("processus = %+v", procs)
+			}
+			p := procs[1]
+			if p.PID != 42 || p.Name != "nginx" || p.Username != "www" || p.CPUPercent != 3.5 || p.MemPercent != 1.25 || p.NumThreads != 4 || p.CmdLine != "nginx -g" {
+				t.Errorf("processus = %+v", p)
+			}
+		}},
+		{"sans horodatage", parse("cpu,host=web-1,cpu=cpu0 usage_idle=50"), []string{"web-1"}, func(t *testing.T, got []SystemData) {
+			if got[0].CollectedAt != now.Format(time.RFC3339) {
+				t.Errorf("CollectedAt = %q, attendu l'heure de réception", got[0].CollectedAt)
+			}
+		}},
+		{"hôte connu complété", parse(`procstat,host=db-1,process_name=postgres pid=9i 1756900740`), []string{"db-1"}, func(t *testing.T, got []SystemData) {
+			if got[0].OS != "linux" || len(got[0].CoreData) != 1 || got[0].CoreData[0].CPUPercent != 5 {
+				t.Errorf("instantané = %+v, attendu l'OS et les cœurs connus", got[0])
+			}
+		}},
+		{"ignorés", parse(
+			"mem,host=web-1 used_percent=40",
+			"cpu,cpu=cpu0 usage_idle=50",
+			"cpu,host=web-1,cpu=cpu0 usage_user=50",
+		), []string{"web-1"}, func(t *testing.T, got []SystemData) {
+			if len(got[0].CoreData) != 0 || len(got[0].Processes) != 0 {
+				t.Errorf("instantané = %+v", got[0])
+			}
+		}},
+		{"plusieurs hôtes", parse(
+			"cpu,host=web-2,cpu=cpu0 usage_idle=50",
+			"cpu,host=web-1,cpu=cpu0 usage_idle=50",
+		), []string{"web-1", "web-2"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snapshotsFromPoints(tt.points, now)
+			var hostnames []string
+			for _, data := range got {
+				hostnames = append(hostnames, data.Hostname)
+			}
+			if !reflect.DeepEqual(hostnames, tt.hostnames) {
+				t.Fatalf("hôtes = %v, attendu %v", hostnames, tt.hostnames)
+			}
+			if tt.check != nil {
+				tt.check(t, got)
+			}
+		})
+	}
+}
+
+// Une seule ligne invalide fait rejeter tout le lot
+func TestHandleWriteRejectsBatch(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+	registry = NewRegistry()
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		body   string
+		want   int
+	}{
+		{"méthode", http.MethodGet, "", "", http.StatusMethodNotAllowed},
+		{"précision inconnue", http.MethodPost, "?precision=h", "cpu,host=web-1,cpu=cpu0 usage_idle=50", http.StatusBadRequest},
+		{"ligne invalide en fin de lot", http.MethodPost, "?precision=s",
+			"cpu,host=web-1,cpu=cpu0 usage_idle=50 1756900740\ncpu,host=web-2,cpu=cpu0 usage_idle=", http.StatusBadRequest},
+		{"ligne invalide en début de lot", http.MethodPost, "",
+			"cpu,host=web-1 usage_idle=\"\n# commentaire\ncpu,host=web-2,cpu=cpu0 usage_idle=50", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/write"+tt.query, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handleWrite(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("statut %d, attendu %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if clients, _ := registry.Snapshot(); len(clients) != 0 {
+				t.Errorf("hôtes enregistrés malgré le rejet: %v", clients)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// États de fraîcheur d'un hôte
+const (
+	StatusOnline  = "online"
+	StatusStale   = "stale"
+	StatusOffline = "offline"
+)
+
+// Intervalle supposé tant qu'aucune cadence n'a été observée (celui de Cpu_agent)
+const defaultPushInterval = 30 * time.Second
+
+// Poids d'un nouvel intervalle dans la moyenne mobile exponentielle
+const intervalSmoothing = 0.3
+
+// Nombre de transitions gardées par hôte
+const maxTransitions = 100
+
+// Seuils de fraîcheur, en multiples de l'intervalle attendu de l'hôte
+type LivenessConfig struct {
+	StaleFactor   float64
+	OfflineFactor float64
+	CheckInterval time.Duration
+}
+
+func defaultLivenessConfig() LivenessConfig {
+	return LivenessConfig{StaleFactor: 2, OfflineFactor: 5, CheckInterval: 5 * time.Second}
+}
+
+// Changement d'état d'un hôte
+type LivenessTransition struct {
+	Hostname string    `json:"hostname"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	At       time.Time `json:"at"`
+}
+
+type hostLiveness struct {
+	status      string
+	lastSeen    time.Time // heure serveur du dernier envoi
+	lastCollect time.Time // collected_at du dernier envoi
+	interval    time.Duration
+	learned     bool
+	restored    bool // rechargé du stockage, pas encore revu
+	transitions []LivenessTransition
+}
+
+// Suivi de fraîcheur : la cadence de chaque hôte est apprise à partir des
+// écarts entre ses collected_at, l'âge est mesuré avec l'horloge du serveur
+// pour ne pas dépendre de celle de l'agent
+type Liveness struct {
+	mu    sync.Mutex
+	cfg   LivenessConfig
+	hosts map[string]*hostLiveness
+}
+
+func NewLiveness(cfg LivenessConfig) *Liveness {
+	return &Liveness{cfg: cfg, hosts: make(map[string]*hostLiveness)}
+}
+
+// Enregistre un envoi ; l'hôte repasse en ligne immédiatement
+func (l *Liveness) Observe(hostname string, collectedAt, now time.Time) (LivenessTransition, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.hosts[hostname]
+	if !ok {
+		h = &hostLiveness{interval: defaultPushInterval}
+		l.hosts[hostname] = h
+	}
+	// l'écart avec un instantané restauré inclut l'arrêt du serveur : pas d'apprentissage
+	if !h.restored && !h.lastCollect.IsZero() && collectedAt.After(h.lastCollect) {
+		delta := collectedAt.Sub(h.lastCollect)
+		if !h.learned {
+			h.interval = delta
+			h.learned = true
+		} else {
+			h.interval = time.Duration(intervalSmoothing*float64(delta) + (1-intervalSmoothing)*float64(h.interval))
+		}
+	}
+	h.lastCollect = collectedAt
+	h.lastSeen = now
+	h.restored = false
+	return l.setStatus(hostname, h, StatusOnline, now)
+}
+
+// Déclare un hôte rechargé du stockage, "stale" jusqu'à son prochain envoi
+func (l *Liveness) Restore(hostname string, collectedAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.hosts[hostname]; ok {
+		return
+	}
+	l.hosts[hostname] = &hostLiveness{
+		status:      StatusStale,
+		restored:    true,
+		lastSeen:    collectedAt,
+		lastCollect: collectedAt,
+		interval:    defaultPushInterval,
+	}
+}
+
+// Réévalue tous les hôtes et renvoie les transitions survenues
+func (l *Liveness) Evaluate(now time.Time) []LivenessTransition {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var changes []LivenessTransition
+	for hostname, h := range l.hosts {
+		age := now.Sub(h.lastSeen)
+		status := StatusOnline
+		switch {
+		case age > time.Duration(l.cfg.OfflineFactor*float64(h.interval)):
+			status = StatusOffline
+		case age > time.Duration(l.cfg.StaleFactor*float64(h.interval)):
+			status = StatusStale
+		case h.restored:
+			status = StatusStale
+		}
+		if t, ok := l.setStatus(hostname, h, status, now); ok {
+			changes = append(changes, t)
+		}
+	}
+	return changes
+}
+
+func (l *Liveness) setStatus(hostname string, h *hostLiveness, status string, now time.Time) (LivenessTransition, bool) {
+	if h.status == status {
+		return LivenessTransition{}, false
+	}
+	t := LivenessTransition{Hostname: hostname, From: h.status, To: status, At: now}
+	h.status = status
+	h.transitions = append(h.transitions, t)
+	if len(h.transitions) > maxTransitions {
+		h.transitions = h.transitions[len(h.transitions)-maxTransitions:]
+	}
+	return t, true
+}
+
+// État et intervalle attendu d'un hôte
+func (l *Liveness) Status(hostname string) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.hosts[hostname]
+	if !ok {
+		return StatusOffline, defaultPushInterval
+	}
+	return h.status, h.interval
+}
+
+// Transitions enregistrées (d'un hôte, ou de tous si hostname est vide), plus anciennes d'abord
+func (l *Liveness) Transitions(hostname string) []LivenessTransition {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := []LivenessTransition{}
+	for name, h := range l.hosts {
+		if hostname == "" || hostname == name {
+			result = append(result, h.transitions...)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].At.Before(result[j].At) })
+	return result
+}
+
+func (l *Liveness) Forget(hostname string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.hosts, hostname)
+}
+
+// Réévalue périodiquement les hôtes et journalise les transitions
+func startLivenessChecker(l *Liveness) {
+	go func() {
+		ticker := time.NewTicker(l.cfg.CheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			for _, t := range l.Evaluate(now.UTC()) {
+				logTransition(t)
+				events.Publish(Event{Type: EventLiveness, Hostname: t.Hostname, Data: t})
+			}
+		}
+	}()
+}
+
+func logTransition(t LivenessTransition) {
+	switch t.To {
+	case StatusOffline:
+		log.Printf("🔴 %s hors ligne (était %s)", t.Hostname, t.From)
+	case StatusStale:
+		log.Printf("🟡 %s sans nouvelles (était %s)", t.Hostname, t.From)
+	default:
+		fmt.Printf("🟢 %s en ligne\n", t.Hostname)
+	}
+}
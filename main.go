@@ -1,24 +1,198 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
 )
 
 func main() {
+	flag.DurationVar(&retention.Raw, "retention-raw", retention.Raw, "durée de conservation des instantanés bruts")
+	flag.DurationVar(&retention.Tiers[0].Keep, "retention-1m", retention.Tiers[0].Keep, "durée de conservation des agrégats 1m")
+	flag.DurationVar(&retention.Tiers[1].Keep, "retention-5m", retention.Tiers[1].Keep, "durée de conservation des agrégats 5m")
+	flag.DurationVar(&retention.Tiers[2].Keep, "retention-1h", retention.Tiers[2].Keep, "durée de conservation des agrégats 1h")
+	flag.DurationVar(&retention.Interval, "compact-interval", retention.Interval, "période du compacteur")
+	storeKind := flag.String("store", "file", "stockage: file, memory ou sql")
+	storeDriver := flag.String("store-driver", "sqlite3", "pilote database/sql (stockage sql, SQLite embarqué par défaut)")
+	storeDSN := flag.String("store-dsn", filepath.Join("infoPc", "monitor.db"), "source de données (stockage sql)")
+	livenessCfg := defaultLivenessConfig()
+	flag.Float64Var(&livenessCfg.StaleFactor, "stale-factor", livenessCfg.StaleFactor, "hôte stale après N intervalles sans envoi")
+	flag.Float64Var(&livenessCfg.OfflineFactor, "offline-factor", livenessCfg.OfflineFactor, "hôte offline après N intervalles sans envoi")
+	flag.DurationVar(&livenessCfg.CheckInterval, "liveness-check", livenessCfg.CheckInterval, "période d'évaluation de la fraîcheur")
+	alertRules := flag.String("alert-rules", "alerts.json", "fichier de règles d'alerte (ignoré s'il n'existe pas)")
+	alertInterval := flag.Duration("alert-interval", 10*time.Second, "période de réévaluation des alertes (durées for, hôtes hors ligne)")
+	notifiers := flag.String("notifiers", "notifiers.json", "configuration des canaux de notification (ignorée si absente)")
+	walWindow := flag.Duration("wal-batch-window", 2*time.Millisecond, "attente maximale pour grouper les fsync du journal")
+	walCheckpoint := flag.Duration("wal-checkpoint", time.Minute, "période de vidage du journal d'ingestion")
+	flag.IntVar(&metricsCfg.TopProcesses, "metrics-top-processes", metricsCfg.TopProcesses, "processus exposés par hôte sur /metrics (top CPU et top mémoire)")
+	flag.IntVar(&metricsCfg.MaxProcessSeries, "metrics-max-process-series", metricsCfg.MaxProcessSeries, "nombre maximal de séries de processus sur /metrics")
+	agentAuth := flag.String("agent-auth", "auto", "authentification des agents: auto (exigée dès la première clé émise), required ou off")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("CPU_MONITOR_ADMIN_TOKEN"), "jeton de l'API d'administration (défaut: $CPU_MONITOR_ADMIN_TOKEN)")
+	listen := flag.String("listen", ":8888", "adresse d'écoute")
+	var tlsCfg TLSConfig
+	flag.StringVar(&tlsCfg.CertFile, "tls-cert", "", "certificat PEM du serveur (active HTTPS)")
+	flag.StringVar(&tlsCfg.KeyFile, "tls-key", "", "clé privée PEM du serveur")
+	flag.StringVar(&tlsCfg.ClientCA, "tls-client-ca", "", "CA des certificats clients (mTLS, le CN identifie l'agent)")
+	flag.StringVar(&tlsCfg.ClientAuth, "tls-client-auth", "request", "certificat client: request (vérifié si présenté) ou require")
+	sessionTTL := flag.Duration("session-ttl", 12*time.Hour, "durée d'inactivité avant expiration d'une session")
+	flag.Int64Var(&ingestLimits.MaxBody, "max-body", ingestLimits.MaxBody, "taille maximale d'un envoi en octets (avant et après décompression)")
+	flag.IntVar(&ingestLimits.MaxCores, "max-cores", ingestLimits.MaxCores, "nombre maximal de cœurs par instantané")
+	flag.IntVar(&ingestLimits.MaxProcesses, "max-processes", ingestLimits.MaxProcesses, "nombre maximal de processus par instantané")
+	rateCfg := defaultRateLimitConfig()
+	flag.Float64Var(&rateCfg.HostRate, "rate-host", rateCfg.HostRate, "envois par seconde autorisés par hôte (0: sans limite)")
+	flag.Float64Var(&rateCfg.HostBurst, "rate-host-burst", rateCfg.HostBurst, "rafale tolérée par hôte")
+	flag.Float64Var(&rateCfg.GlobalRate, "rate-global", rateCfg.GlobalRate, "envois par seconde autorisés tous hôtes confondus (0: sans limite)")
+	flag.Float64Var(&rateCfg.GlobalBurst, "rate-global-burst", rateCfg.GlobalBurst, "rafale tolérée tous hôtes confondus")
+	loginCfg := defaultLoginLimitConfig()
+	flag.Float64Var(&loginCfg.HostRate, "login-rate", loginCfg.HostRate, "tentatives de connexion par seconde autorisées par adresse (0: sans limite)")
+	flag.Float64Var(&loginCfg.HostBurst, "login-burst", loginCfg.HostBurst, "rafale de tentatives de connexion tolérée par adresse")
+	loginConcurrency := flag.Int("login-concurrency", cap(loginSlots), "vérifications de mot de passe simultanées (64 Mio chacune)")
+	ingestWorkers := flag.Int("ingest-workers", 4, "workers d'écriture des instantanés")
+	ingestQueueSize := flag.Int("ingest-queue", 64, "envois en attente avant refus (503)")
+	groupsFile := flag.String("host-groups", "groups.json", "groupes d'hôtes pour le contrôle d'accès (ignoré s'il n'existe pas)")
+	flag.Parse()
+	liveness = NewLiveness(livenessCfg)
+	limiter = NewRateLimiter(rateCfg)
+	loginLimiter = NewRateLimiter(loginCfg)
+	if *loginConcurrency < 1 {
+		log.Fatalf("❌ -login-concurrency doit être au moins 1")
+	}
+	loginSlots = make(chan struct{}, *loginConcurrency)
+	ingestQueue = NewIngestQueue(*ingestWorkers, *ingestQueueSize)
+
+	if rules, err := LoadAlertRules(*alertRules); err == nil {
+		alerts = NewAlertEngine(rules)
+		fmt.Printf("🚨 %d règle(s) d'alerte chargée(s) depuis %s\n", len(rules), *alertRules)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("❌ Règles d'alerte invalides: %v", err)
+	}
+
+	if groups, err := LoadHostGroups(*groupsFile); err == nil {
+		hostGroups = groups
+		fmt.Printf("👥 %d groupe(s) d'hôtes chargé(s) depuis %s\n", len(groups), *groupsFile)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("❌ Groupes d'hôtes invalides: %v", err)
+	}
+
 	if _, err := os.Stat("infoPc"); os.IsNotExist(err) {
 		_ = os.Mkdir("infoPc", os.ModePerm)
 	}
 
+	st, err := openStore(StoreConfig{
+		Kind:      *storeKind,
+		Dir:       "infoPc",
+		Driver:    *storeDriver,
+		DSN:       *storeDSN,
+		Retention: retention,
+	})
+	if err != nil {
+		log.Fatalf("❌ Impossible d'ouvrir le stockage: %v", err)
+	}
+	defer st.Close()
+	store = st
+
+	wal, err := OpenWAL(filepath.Join("infoPc", "ingest.wal"), *walWindow)
+	if err != nil {
+		log.Fatalf("❌ Impossible d'ouvrir le journal: %v", err)
+	}
+	defer wal.Close()
+	if err := replayWAL(wal, st); err != nil {
+		log.Fatalf("❌ Rejeu du journal impossible: %v", err)
+	}
+	ingestWAL = wal
+	startCheckpointer(wal, st, *walCheckpoint)
+
+	// Migration d'un serveur sans authentification : en mode auto, les
+	// agents existants continuent d'envoyer sans jeton tant qu'aucune clé
+	// n'existe ; émettre alors une clé par hôte (POST /api/admin/agent-keys)
+	// et la déployer (-token de l'agent) : dès la première clé émise, tout
+	// envoi doit être authentifié
+	switch *agentAuth {
+	case "required", "auto":
+		keys, err := OpenAgentKeyStore(filepath.Join("infoPc", "agent_keys.json"))
+		if err != nil {
+			log.Fatalf("❌ Clés des agents illisibles: %v", err)
+		}
+		keys.auto = *agentAuth == "auto"
+		agentKeys = keys
+		if keys.Enforced() {
+			fmt.Printf("🔑 Authentification des agents activée (%d clé(s))\n", len(keys.List()))
+		} else {
+			log.Printf("⚠️  Aucune clé d'agent : envois non authentifiés acceptés jusqu'à l'émission de la première clé")
+		}
+	case "off":
+		log.Printf("⚠️  Authentification des agents désactivée : tout client peut envoyer des données")
+	default:
+		log.Fatalf("❌ -agent-auth doit valoir auto, required ou off")
+	}
+
+	accounts, err := user.OpenStore(filepath.Join("infoPc", "users.json"))
+	if err != nil {
+		log.Fatalf("❌ Comptes illisibles: %v", err)
+	}
+	users = accounts
+	sessions = user.NewSessions(*sessionTTL)
+	if err := ensureAdminUser("infoPc"); err != nil {
+		log.Fatalf("❌ Création du compte admin impossible: %v", err)
+	}
+
+	if err := restoreClients(st); err != nil {
+		log.Fatalf("❌ Restauration des clients impossible: %v", err)
+	}
+	startCompactor(st, retention.Interval)
+	startLivenessChecker(liveness)
+
+	if cfg, err := LoadNotifierConfig(*notifiers); err == nil {
+		n, err := NewNotifier(cfg, filepath.Join("infoPc", "notify"))
+		if err != nil {
+			log.Fatalf("❌ Notifications invalides: %v", err)
+		}
+		n.Start()
+		notifier = n
+		fmt.Printf("📣 %d canal(aux) de notification\n", len(cfg.Sinks))
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("❌ Notifications invalides: %v", err)
+	}
+	startAlertEvaluator(*alertInterval)
+
 	http.HandleFunc("/", serveIndex)
 	http.Handle("/static2/", http.StripPrefix("/static2/", http.FileServer(http.Dir("static2"))))
-	http.HandleFunc("/cpu", handleCPU)
-	http.HandleFunc("/api/clients", handleClients)
-	http.HandleFunc("/api/stats", handleStats)
-	http.HandleFunc("/api/processes", handleProcesses)
+	http.HandleFunc("/cpu", requireAgent(handleCPU))
+	http.HandleFunc("/write", requireAgent(handleWrite))
+	http.HandleFunc("/v1/metrics", requireAgent(handleOTLPMetrics))
+	http.HandleFunc("/api/clients", authorize(user.RoleViewer, user.RoleOperator, handleClients))
+	http.HandleFunc("/api/stats", authorize(user.RoleViewer, user.RoleOperator, handleStats))
+	http.HandleFunc("/api/processes", authorize(user.RoleViewer, user.RoleOperator, handleProcesses))
+	http.HandleFunc("/api/processes/search", authorize(user.RoleViewer, user.RoleOperator, handleProcessSearch))
+	http.HandleFunc("/api/history", authorize(user.RoleViewer, user.RoleOperator, handleHistory))
+	http.HandleFunc("/api/liveness", authorize(user.RoleViewer, user.RoleOperator, handleLiveness))
+	http.HandleFunc("/api/alerts", authorize(user.RoleViewer, user.RoleOperator, handleAlerts))
+	http.HandleFunc("/api/events", authorize(user.RoleViewer, user.RoleOperator, handleEvents))
+	http.HandleFunc("/api/session", authorize(user.RoleViewer, user.RoleOperator, handleSession))
+	http.HandleFunc("/api/keys", authorize(user.RoleViewer, user.RoleViewer, handleAPIKeys))
+	http.HandleFunc("/api/admin/agent-keys", authorize(user.RoleAdmin, user.RoleAdmin, handleAgentKeys))
+	http.HandleFunc("/api/admin/users", authorize(user.RoleAdmin, user.RoleAdmin, handleUsers))
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	// scrape avec une clé d'API (bearer) : les séries suivent ses groupes
+	http.HandleFunc("/metrics", authorize(user.RoleViewer, user.RoleViewer, handleMetrics))
 
-	fmt.Println("🚀 Serveur CPU Monitor démarré sur :8888")
-	log.Fatal(http.ListenAndServe(":8888", nil))
+	server := &http.Server{Addr: *listen, ReadHeaderTimeout: 10 * time.Second}
+	if tlsCfg.Enabled() {
+		cfg, err := buildServerTLS(tlsCfg)
+		if err != nil {
+			log.Fatalf("❌ Configuration TLS invalide: %v", err)
+		}
+		server.TLSConfig = cfg
+		fmt.Printf("🔒 Serveur CPU Monitor démarré en HTTPS sur %s\n", *listen)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	fmt.Printf("🚀 Serveur CPU Monitor démarré sur %s\n", *listen)
+	log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Limites de cardinalité des séries de processus
+type MetricsConfig struct {
+	TopProcesses     int // processus exposés par hôte (top CPU ∪ top mémoire)
+	MaxProcessSeries int // plafond global, tous hôtes confondus
+}
+
+func defaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{TopProcesses: 10, MaxProcessSeries: 1000}
+}
+
+var metricsCfg = defaultMetricsConfig()
+
+// Compteurs internes du serveur
+type serverCounters struct {
+	ingestRequests   atomic.Uint64
+	ingestBytes      atomic.Uint64
+	decodeErrors     atomic.Uint64
+	validationErrors atomic.Uint64
+	storeErrors      atomic.Uint64
+	authFailures     atomic.Uint64
+	rateLimited      atomic.Uint64
+	shed             atomic.Uint64
+	deltas           atomic.Uint64
+	resyncs          atomic.Uint64
+}
+
+var counters serverCounters
+
+var startTime = time.Now()
+
+// Compte les octets lus dans le corps d'une requête
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Écrit les familles de métriques au format texte Prometheus ou OpenMetrics
+type metricsWriter struct {
+	buf         bytes.Buffer
+	openMetrics bool
+}
+
+func (m *metricsWriter) family(name, kind, help string) {
+	// en OpenMetrics, le nom d'une famille de compteurs ne porte pas _total
+	if m.openMetrics && kind == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&m.buf, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+// Processus exposés pour un hôte : union des top N CPU et mémoire
+func metricsProcesses(procs []ProcessInfo, n int) []ProcessInfo {
+	if n <= 0 || len(procs) == 0 {
+		return nil
+	}
+	picked := make(map[int32]bool)
+	var result []ProcessInfo
+	byCPU := func(a, b ProcessInfo) bool { return a.CPUPercent > b.CPUPercent }
+	byMem := func(a, b ProcessInfo) bool { return a.MemPercent > b.MemPercent }
+	for _, less := range []func(a, b ProcessInfo) bool{byCPU, byMem} {
+		sorted := append([]ProcessInfo(nil), procs...)
+		sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+		if len(sorted) > n {
+			sorted = sorted[:n]
+		}
+		for _, p := range sorted {
+			if !picked[p.PID] {
+				picked[p.PID] = true
+				result = append(result, p)
+			}
+		}
+	}
+	return result
+}
+
+// Exposition Prometheus, limitée aux hôtes visibles par l'appelant
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &metricsWriter{openMetrics: strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")}
+	clients, metas := visibleClients(r)
+	p := principalFrom(r)
+
+	hostnames := make([]string, 0, len(clients))
+	for hostname := range clients {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+
+	m.family("cpumon_host_info", "gauge", "Informations sur l'hôte et son processeur.")
+	for _, h := range hostnames {
+		data := clients[h]
+		m.sample("cpumon_host_info", 1,
+			"hostname", h, "os", data.OS, "platform", data.Platform,
+			"vendor", data.CPUInfo.VendorID, "family", data.CPUInfo.Family, "model", data.CPUInfo.Model,
+			"mhz", data.CPUInfo.MHz, "cache_size", data.CPUInfo.CacheSize)
+	}
+
+	m.family("cpumon_host_up", "gauge", "1 si l'hôte est en ligne.")
+	for _, h := range hostnames {
+		status, _ := liveness.Status(h)
+		up := 0.0
+		if status == StatusOnline {
+			up = 1
+		}
+		m.sample("cpumon_host_up", up, "hostname", h)
+	}
+
+	m.family("cpumon_host_last_seen_timestamp_seconds", "gauge", "Heure du dernier envoi reçu.")
+	for _, h := range hostnames {
+		m.sample("cpumon_host_last_seen_timestamp_seconds", float64(metas[h].LastSeen.UnixNano())/1e9, "hostname", h)
+	}
+
+	m.family("cpumon_host_cpu_percent", "gauge", "Utilisation CPU moyenne des cœurs.")
+	for _, h := range hostnames {
+		cores := clients[h].CoreData
+		if len(cores) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, core := range cores {
+			total += core.CPUPercent
+		}
+		m.sample("cpumon_host_cpu_percent", total/float64(len(cores)), "hostname", h)
+	}
+
+	m.family("cpumon_core_cpu_percent", "gauge", "Utilisation CPU par cœur.")
+	for _, h := range hostnames {
+		for _, core := range clients[h].CoreData {
+			m.sample("cpumon_core_cpu_percent", core.CPUPercent, "hostname", h, "core", strconv.Itoa(core.Core))
+		}
+	}
+
+	m.family("cpumon_host_processes", "gauge", "Nombre de processus remontés.")
+	for _, h := range hostnames {
+		m.sample("cpumon_host_processes", float64(len(clients[h].Processes)), "hostname", h)
+	}
+
+	// Séries de processus, bornées par hôte puis globalement
+	type hostProcess struct {
+		hostname string
+		proc     ProcessInfo
+	}
+	var exposed []hostProcess
+	dropped := 0
+	for _, h := range hostnames {
+		for _, p := range metricsProcesses(clients[h].Processes, metricsCfg.TopProcesses) {
+			if len(exposed) >= metricsCfg.MaxProcessSeries {
+				dropped++
+				continue
+			}
+			exposed = append(exposed, hostProcess{h, p})
+		}
+	}
+	m.family("cpumon_process_cpu_percent", "gauge", "Utilisation CPU des principaux processus.")
+	for _, e := range exposed {
+		p := e.proc
+		m.sample("cpumon_process_cpu_percent", p.CPUPercent, "hostname", e.hostname, "pid", strconv.Itoa(int(p.PID)), "name", p.Name, "user", p.Username)
+	}
+	m.family("cpumon_process_memory_percent", "gauge", "Utilisation mémoire des principaux processus.")
+	for _, e := range exposed {
+		p := e.proc
+		m.sample("cpumon_process_memory_percent", float64(p.MemPercent), "hostname", e.hostname, "pid", strconv.Itoa(int(p.PID)), "name", p.Name, "user", p.Username)
+	}
+	m.family("cpumon_process_series_dropped", "gauge", "Processus non exposés à cause du plafond de séries.")
+	m.sample("cpumon_process_series_dropped", float64(dropped))
+
+	firing := 0
+	for _, a := range alerts.List(false) {
+		if a.State == AlertFiring && p.CanSeeHost(a.Hostname) {
+			firing++
+		}
+	}
+	m.family("cpumon_alerts_firing", "gauge", "Alertes déclenchées.")
+	m.sample("cpumon_alerts_firing", float64(firing))
+
+	m.family("cpumon_ingest_requests_total", "counter", "Envois reçus sur les points d'ingestion.")
+	m.sample("cpumon_ingest_requests_total", float64(counters.ingestRequests.Load()))
+	m.family("cpumon_ingest_bytes_total", "counter", "Octets de charge utile reçus.")
+	m.sample("cpumon_ingest_bytes_total", float64(counters.ingestBytes.Load()))
+	m.family("cpumon_ingest_decode_errors_total", "counter", "Envois rejetés car illisibles.")
+	m.sample("cpumon_ingest_decode_errors_total", float64(counters.decodeErrors.Load()))
+	m.family("cpumon_ingest_validation_errors_total", "counter", "Envois rejetés par la validation.")
+	m.sample("cpumon_ingest_validation_errors_total", float64(counters.validationErrors.Load()))
+	m.family("cpumon_ingest_store_errors_total", "counter", "Envois non enregistrés.")
+	m.sample("cpumon_ingest_store_errors_total", float64(counters.storeErrors.Load()))
+	m.family("cpumon_ingest_auth_failures_total", "counter", "Envois refusés faute d'identifiants valides.")
+	m.sample("cpumon_ingest_auth_failures_total", float64(counters.authFailures.Load()))
+	m.family("cpumon_ingest_rate_limited_total", "counter", "Envois refusés par la limite de débit (429).")
+	m.sample("cpumon_ingest_rate_limited_total", float64(counters.rateLimited.Load()))
+	m.family("cpumon_ingest_shed_total", "counter", "Envois refusés car la file d'ingestion était pleine (503).")
+	m.sample("cpumon_ingest_shed_total", float64(counters.shed.Load()))
+	m.family("cpumon_ingest_delta_total", "counter", "Instantanés reçus en delta et reconstruits.")
+	m.sample("cpumon_ingest_delta_total", float64(counters.deltas.Load()))
+	m.family("cpumon_ingest_resync_total", "counter", "Deltas refusés faute de base, instantané complet demandé (409).")
+	m.sample("cpumon_ingest_resync_total", float64(counters.resyncs.Load()))
+	m.family("cpumon_ingest_queue_depth", "gauge", "Envois en attente dans la file d'ingestion.")
+	m.sample("cpumon_ingest_queue_depth", float64(ingestQueue.Len()))
+	m.family("cpumon_event_subscribers", "gauge", "Tableaux de bord connectés au flux d'événements.")
+	m.sample("cpumon_event_subscribers", float64(events.Count()))
+	m.family("cpumon_start_time_seconds", "gauge", "Heure de démarrage du serveur.")
+	m.sample("cpumon_start_time_seconds", float64(startTime.Unix()))
+
+	if m.openMetrics {
+		m.buf.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write(m.buf.Bytes())
+}
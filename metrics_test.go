@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+func TestEscapeLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"web-1", "web-1"},
+		{`C:\Windows`, `C:\\Windows`},
+		{`say "hi"`, `say \"hi\"`},
+		{"a\nb", `a\nb`},
+		{"\\\"\n", `\\\"\n`},
+	}
+	for _, tt := range tests {
+		if got := escapeLabel(tt.in); got != tt.want {
+			t.Errorf("escapeLabel(%q) = %q, attendu %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		openMetrics bool
+		write       func(m *metricsWriter)
+		want        string
+	}{
+		{"compteur Prometheus", false, func(m *metricsWriter) {
+			m.family("cpumon_ingest_requests_total", "counter", "Envois.")
+			m.sample("cpumon_ingest_requests_total", 42)
+		}, "# HELP cpumon_ingest_requests_total Envois.\n# TYPE cpumon_ingest_requests_total counter\ncpumon_ingest_requests_total 42\n"},
+		{"compteur OpenMetrics", true, func(m *metricsWriter) {
+			m.family("cpumon_ingest_requests_total", "counter", "Envois.")
+			m.sample("cpumon_ingest_requests_total", 42)
+		}, "# HELP cpumon_ingest_requests Envois.\n# TYPE cpumon_ingest_requests counter\ncpumon_ingest_requests_total 42\n"},
+		{"jauge OpenMetrics", true, func(m *metricsWriter) {
+			m.family("cpumon_host_up", "gauge", "En ligne.")
+		}, "# HELP cpumon_host_up En ligne.\n# TYPE cpumon_host_up gauge\n"},
+		{"labels échappés", false, func(m *metricsWriter) {
+			m.sample("cpumon_process_cpu_percent", 12.5, "hostname", "web-1", "name", `a"b`)
+		}, "cpumon_process_cpu_percent{hostname=\"web-1\",name=\"a\\\"b\"} 12.5\n"},
+		{"valeur décimale", false, func(m *metricsWriter) {
+			m.sample("cpumon_start_time_seconds", 1.7e9)
+		}, "cpumon_start_time_seconds 1.7e+09\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &metricsWriter{openMetrics: tt.openMetrics}
+			tt.write(m)
+			if got := m.buf.String(); got != tt.want {
+				t.Errorf("sortie:\n%s\nattendu:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsProcesses(t *testing.T) {
+	procs := []ProcessInfo{
+		{PID: 1, CPUPercent: 50, MemPercent: 1},
+		{PID: 2, CPUPercent: 40, MemPercent: 2},
+		{PID: 3, CPUPercent: 1, MemPercent: 30},
+		{PID: 4, CPUPercent: 2, MemPercent: 20},
+	}
+	pids := func(procs []ProcessInfo) []int32 {
+		var result []int32
+		for _, p := range procs {
+			result = append(result, p.PID)
+		}
+		return result
+	}
+	tests := []struct {
+		name string
+		n    int
+		want []int32
+	}{
+		{"désactivé", 0, nil},
+		{"top 1 CPU et mémoire", 1, []int32{1, 3}},
+		{"top 2", 2, []int32{1, 2, 3, 4}},
+		{"plus que de processus", 10, []int32{1, 2, 4, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pids(metricsProcesses(procs, tt.n)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PID = %v, attendu %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	savedRegistry, savedGroups, savedCfg := registry, hostGroups, metricsCfg
+	defer func() { registry, hostGroups, metricsCfg = savedRegistry, savedGroups, savedCfg }()
+	db := &HostGroup{Name: "db", Hostnames: []string{"db-.*"}}
+	if err := db.compile(); err != nil {
+		t.Fatal(err)
+	}
+	hostGroups = map[string]*HostGroup{"db": db}
+	registry = NewRegistry()
+	metricsCfg = MetricsConfig{TopProcesses: 1, MaxProcessSeries: 2}
+	now := time.Now()
+	for _, data := range []SystemData{
+		{Hostname: "web-1", OS: "linux", CoreData: []CPUClientCoreData{{Core: 0, CPUPercent: 20}, {Core: 1, CPUPercent: 40}},
+			Processes: []ProcessInfo{{PID: 10, Name: "nginx", CPUPercent: 30, MemPercent: 1}, {PID: 11, Name: "java", CPUPercent: 1, MemPercent: 40}}},
+		{Hostname: "db-1", OS: "linux",
+			Processes: []ProcessInfo{{PID: 20, Name: "postgres", CPUPercent: 80, MemPercent: 60}}},
+	} {
+		registry.Update(data, "192.0.2.1", now)
+	}
+
+	admin := Principal{Role: user.RoleAdmin}
+	tests := []struct {
+		name        string
+		p           Principal
+		accept      string
+		contentType string
+		want        []string
+		wantNot     []string
+	}{
+		{"Prometheus", admin, "", "text/plain; version=0.0.4; charset=utf-8",
+			[]string{
+				"# TYPE cpumon_ingest_requests_total counter\n",
+				"cpumon_host_cpu_percent{hostname=\"web-1\"} 30\n",
+				"cpumon_core_cpu_percent{hostname=\"web-1\",core=\"1\"} 40\n",
+				"cpumon_process_cpu_percent{hostname=\"db-1\",pid=\"20\",name=\"postgres\",user=\"\"} 80\n",
+				// top 1 CPU ∪ top 1 mémoire, plafond de 2 séries
+				"cpumon_process_cpu_percent{hostname=\"web-1\",pid=\"10\",name=\"nginx\",user=\"\"} 30\n",
+				"cpumon_process_series_dropped 1\n",
+			},
+			[]string{"cpumon_host_cpu_percent{hostname=\"db-1\"}", "name=\"java\"", "# EOF"}},
+		{"OpenMetrics", admin, "application/openmetrics-text; version=1.0.0", "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			[]string{
+				"# TYPE cpumon_ingest_requests counter\n",
+				"cpumon_ingest_requests_total ",
+				"cpumon_host_processes{hostname=\"web-1\"} 2\n",
+			},
+			[]string{"# TYPE cpumon_ingest_requests_total"}},
+		{"hôtes visibles seulement", Principal{Login: "bob", Role: user.RoleViewer, Groups: []string{"db"}}, "", "text/plain; version=0.0.4; charset=utf-8",
+			[]string{
+				"cpumon_host_processes{hostname=\"db-1\"} 1\n",
+				"cpumon_process_series_dropped 0\n",
+			},
+			[]string{"hostname=\"web-1\""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handleMetrics(rec, req.WithContext(context.WithValue(req.Context(), principalContextKey{}, tt.p)))
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q, attendu %q", ct, tt.contentType)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("%q absent de:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(body, s) {
+					t.Errorf("%q inattendu", s)
+				}
+			}
+			if openMetrics := tt.accept != ""; openMetrics != strings.HasSuffix(body, "# EOF\n") {
+				t.Errorf("terminaison # EOF: %v, attendu %v", !openMetrics, openMetrics)
+			}
+		})
+	}
+}
@@ -1,50 +1,19 @@
 package main
 
-// CPU
-type CPUInfo struct {
-	VendorID   string `json:"vendor"`
-	Family     string `json:"family"`
-	Model      string `json:"model"`
-	MHz        string `json:"mhz"`
-	CacheSize  string `json:"cache_size"`
-	UserAgent  string `json:"user_agent"`
-}
+import "github.com/Tenkydo/monprojet/protocol"
 
-// Données par cœur
-type CPUClientCoreData struct {
-	Core       int     `json:"core"`
-	UserAgent  string  `json:"user_agent"`
-	CPUPercent float64 `json:"cpu_percent"`
-	Timestamp  string  `json:"timestamp"`
-}
-
-// Infos processus
-type ProcessInfo struct {
-	PID         int32   `json:"pid"`
-	Name        string  `json:"name"`
-	CPUPercent  float64 `json:"cpu_percent"`
-	MemPercent  float32 `json:"memory_percent"`
-	Status      string  `json:"status"`
-	Username    string  `json:"username"`
-	CreateTime  int64   `json:"create_time"`
-	CmdLine     string  `json:"cmdline"`
-	NumThreads  int32   `json:"num_threads"`
-}
-
-// Données complètes d’un client
-type SystemData struct {
-	CPUInfo     CPUInfo             `json:"cpu_info"`
-	CoreData    []CPUClientCoreData `json:"core_data"`
-	Processes   []ProcessInfo       `json:"processes"`
-	Hostname    string              `json:"hostname"`
-	OS          string              `json:"os"`
-	Platform    string              `json:"platform"`
-	CollectedAt string              `json:"collected_at"`
-}
+// Types du format d'échange, partagés avec l'agent (voir protocol/)
+type (
+	CPUInfo           = protocol.CPUInfo
+	CPUClientCoreData = protocol.CPUClientCoreData
+	ProcessInfo       = protocol.ProcessInfo
+	SystemData        = protocol.SystemData
+)
 
 // Données pour interface web
 type WebData struct {
 	Clients    map[string]SystemData `json:"clients"`
+	Hosts      map[string]HostMeta   `json:"hosts"`
 	LastUpdate string                `json:"last_update"`
 }
 
@@ -55,4 +24,41 @@ type ProcessStats struct {
 	TotalProcesses  int           `json:"total_processes"`
 	RunningProcs    int           `json:"running_processes"`
 	SleepingProcs   int           `json:"sleeping_processes"`
+	ZombieProcs     int           `json:"zombie_processes"`
+}
+
+// Statistiques CPU d'un groupe d'hôtes
+type CPUStats struct {
+	Avg    float64 `json:"avg"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+}
+
+// Cœur le plus chargé
+type CoreLoad struct {
+	Hostname   string  `json:"hostname"`
+	Core       int     `json:"core"`
+	CPUPercent float64 `json:"cpu_percent"`
+}
+
+// Répartition des hôtes par valeur d'un attribut ; AvgCPU ne porte que
+// sur les hôtes ayant des échantillons
+type Breakdown struct {
+	Hosts  int     `json:"hosts"`
+	AvgCPU float64 `json:"avg_cpu"`
+}
+
+// Statistiques globales du parc
+type FleetStats struct {
+	HostCount    int                  `json:"host_count"`
+	OnlineCount  int                  `json:"online_count"`
+	SampledCount int                  `json:"sampled_count"` // hôtes ayant des échantillons dans la fenêtre
+	Window       string               `json:"window,omitempty"`
+	CPU          CPUStats             `json:"cpu"`
+	HottestCores []CoreLoad           `json:"hottest_cores"`
+	ByOS         map[string]Breakdown `json:"by_os"`
+	ByPlatform   map[string]Breakdown `json:"by_platform"`
+	ByVendor     map[string]Breakdown `json:"by_vendor"`
+	ByModel      map[string]Breakdown `json:"by_model"`
+	ComputedAt   string               `json:"computed_at"`
 }
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification envoyée aux canaux : alertes d'un même hôte regroupées
+type Notification struct {
+	ID       string    `json:"id"`
+	Hostname string    `json:"hostname"`
+	Alerts   []Alert   `json:"alerts"`
+	Firing   []Alert   `json:"firing"`
+	Resolved []Alert   `json:"resolved"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// Canal de notification
+type Sink interface {
+	Name() string
+	Send(n Notification) error
+}
+
+// Configuration d'un canal
+type SinkConfig struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"` // webhook, slack, teams, smtp, file
+	URL        string       `json:"url,omitempty"`
+	Path       string       `json:"path,omitempty"`
+	Template   string       `json:"template,omitempty"`
+	Subject    string       `json:"subject,omitempty"`
+	SMTP       SMTPConfig   `json:"smtp,omitempty"`
+	MaxRetries int          `json:"max_retries,omitempty"`
+	Timeout    ruleDuration `json:"timeout,omitempty"`
+}
+
+// Fichier de configuration des notifications
+type NotifierConfig struct {
+	GroupWait  ruleDuration `json:"group_wait"`
+	BackoffMin ruleDuration `json:"backoff_min"`
+	BackoffMax ruleDuration `json:"backoff_max"`
+	Sinks      []SinkConfig `json:"sinks"`
+}
+
+// Message texte par défaut (Slack, Teams, e-mail, fichier)
+const defaultNotificationTemplate = `{{range .Firing}}🚨 {{if .Severity}}[{{.Severity}}] {{end}}{{.Rule}} sur {{.Hostname}}: {{.Summary}}
+{{end}}{{range .Resolved}}✅ {{.Rule}} résolue sur {{.Hostname}}
+{{end}}`
+
+const defaultSubjectTemplate = `[CPU Monitor] {{.Hostname}}: {{len .Firing}} alerte(s) active(s), {{len .Resolved}} résolue(s)`
+
+// Lit le fichier de configuration des notifications
+func LoadNotifierConfig(path string) (NotifierConfig, error) {
+	cfg := NotifierConfig{
+		GroupWait:  ruleDuration(10 * time.Second),
+		BackoffMin: ruleDuration(5 * time.Second),
+		BackoffMax: ruleDuration(10 * time.Minute),
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Nom de canal : il nomme aussi sa file d'envoi sur disque
+var sinkNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+// Noms de canaux uniques et utilisables comme nom de fichier
+func (cfg NotifierConfig) validate() error {
+	names := make(map[string]bool, len(cfg.Sinks))
+	for i, sc := range cfg.Sinks {
+		switch {
+		case sc.Name == "":
+			return fmt.Errorf("canal %d: name requis", i)
+		case !sinkNamePattern.MatchString(sc.Name) || strings.Contains(sc.Name, ".."):
+			return fmt.Errorf("canal %d: name %q invalide (lettres, chiffres, . _ -, 64 caractères au plus)", i, sc.Name)
+		case names[sc.Name]:
+			return fmt.Errorf("canal %d: name %q déjà utilisé", i, sc.Name)
+		}
+		names[sc.Name] = true
+	}
+	return nil
+}
+
+// Construit le canal décrit par la configuration
+func newSink(cfg SinkConfig) (Sink, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("name requis")
+	}
+	text := cfg.Template
+	if text == "" {
+		text = defaultNotificationTemplate
+	}
+	body, err := template.New(cfg.Name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	switch cfg.Type {
+	case "webhook", "slack", "teams":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url requise")
+		}
+		return newWebhookSink(cfg.Name, cfg.Type, cfg.URL, body, timeout), nil
+	case "smtp":
+		subjectText := cfg.Subject
+		if subjectText == "" {
+			subjectText = defaultSubjectTemplate
+		}
+		subject, err := template.New(cfg.Name + "-subject").Parse(subjectText)
+		if err != nil {
+			return nil, fmt.Errorf("subject: %v", err)
+		}
+		if cfg.SMTP.Addr == "" || cfg.SMTP.From == "" || len(cfg.SMTP.To) == 0 {
+			return nil, fmt.Errorf("smtp: addr, from et to requis")
+		}
+		return &smtpSink{name: cfg.Name, cfg: cfg.SMTP, subject: subject, body: body}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("path requis")
+		}
+		return &fileSink{name: cfg.Name, path: cfg.Path, body: body}, nil
+	default:
+		return nil, fmt.Errorf("type inconnu: %q", cfg.Type)
+	}
+}
+
+func renderTemplate(t *template.Template, n Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+// Élément de la file d'envoi d'un canal
+type queuedNotification struct {
+	Notification Notification `json:"notification"`
+	Attempts     int          `json:"attempts"`
+	NextAttempt  time.Time    `json:"next_attempt"`
+	LastError    string       `json:"last_error,omitempty"`
+}
+
+// File d'envoi persistante d'un canal, avec reprises à délai exponentiel
+type sinkQueue struct {
+	sink       Sink
+	path       string
+	maxRetries int
+	backoffMin time.Duration
+	backoffMax time.Duration
+
+	mu    sync.Mutex
+	items []queuedNotification
+	wake  chan struct{}
+}
+
+func openSinkQueue(sink Sink, dir string, maxRetries int, backoffMin, backoffMax time.Duration) (*sinkQueue, error) {
+	q := &sinkQueue{
+		sink:       sink,
+		path:       filepath.Join(dir, sink.Name()+".queue.json"),
+		maxRetries: maxRetries,
+		backoffMin: backoffMin,
+		backoffMax: backoffMax,
+		wake:       make(chan struct{}, 1),
+	}
+	raw, err := os.ReadFile(q.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &q.items); err != nil {
+			log.Printf("⚠️  File %s illisible, ignorée: %v", q.path, err)
+			q.items = nil
+		}
+	}
+	return q, nil
+}
+
+func (q *sinkQueue) enqueue(n Notification) {
+	q.mu.Lock()
+	q.items = append(q.items, queuedNotification{Notification: n, NextAttempt: time.Now()})
+	err := q.persistLocked()
+	q.mu.Unlock()
+	if err != nil {
+		log.Printf("❌ Sauvegarde file %s: %v", q.path, err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sinkQueue) persistLocked() error {
+	raw, err := json.Marshal(q.items)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, raw, 0600)
+}
+
+// Envoie les éléments dus ; renvoie l'échéance du prochain
+func (q *sinkQueue) process(now time.Time) time.Time {
+	q.mu.Lock()
+	due := make([]queuedNotification, 0, len(q.items))
+	var rest []queuedNotification
+	for _, item := range q.items {
+		if !item.NextAttempt.After(now) {
+			due = append(due, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	q.mu.Unlock()
+
+	var retry []queuedNotification
+	for _, item := range due {
+		err := q.sink.Send(item.Notification)
+		if err == nil {
+			fmt.Printf("📣 Notification %s envoyée via %s\n", item.Notification.ID, q.sink.Name())
+			continue
+		}
+		item.Attempts++
+		item.LastError = err.Error()
+		if item.Attempts > q.maxRetries {
+			log.Printf("❌ Notification %s abandonnée sur %s après %d essais: %v",
+				item.Notification.ID, q.sink.Name(), item.Attempts, err)
+			continue
+		}
+		backoff := q.backoffMin << uint(item.Attempts-1)
+		if backoff > q.backoffMax || backoff <= 0 {
+			backoff = q.backoffMax
+		}
+		item.NextAttempt = now.Add(backoff)
+		log.Printf("⚠️  Échec envoi %s via %s (essai %d, reprise dans %v): %v",
+			item.Notification.ID, q.sink.Name(), item.Attempts, backoff, err)
+		retry = append(retry, item)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// les éléments ajoutés pendant l'envoi sont en fin de q.items
+	added := q.items[len(due)+len(rest):]
+	q.items = append(append(append([]queuedNotification(nil), rest...), retry...), added...)
+	if len(due) > 0 {
+		if err := q.persistLocked(); err != nil {
+			log.Printf("❌ Sauvegarde file %s: %v", q.path, err)
+		}
+	}
+	next := time.Time{}
+	for _, item := range q.items {
+		if next.IsZero() || item.NextAttempt.Before(next) {
+			next = item.NextAttempt
+		}
+	}
+	return next
+}
+
+func (q *sinkQueue) run() {
+	for {
+		next := q.process(time.Now())
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Regroupe les changements d'alertes par hôte et les distribue aux canaux
+type Notifier struct {
+	queues    []*sinkQueue
+	groupWait time.Duration
+
+	mu      sync.Mutex
+	pending map[string][]Alert // par hôte
+	sent    map[string]string  // dernier état notifié par alerte (déduplication)
+	seq     int64
+}
+
+// Crée le notifieur ; les files d'envoi sont persistées dans dir
+func NewNotifier(cfg NotifierConfig, dir string) (*Notifier, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	n := &Notifier{
+		groupWait: time.Duration(cfg.GroupWait),
+		pending:   make(map[string][]Alert),
+		sent:      make(map[string]string),
+	}
+	for _, sc := range cfg.Sinks {
+		sink, err := newSink(sc)
+		if err != nil {
+			return nil, fmt.Errorf("canal %q: %v", sc.Name, err)
+		}
+		maxRetries := sc.MaxRetries
+		if maxRetries == 0 {
+			maxRetries = 10
+		}
+		q, err := openSinkQueue(sink, dir, maxRetries, time.Duration(cfg.BackoffMin), time.Duration(cfg.BackoffMax))
+		if err != nil {
+			return nil, err
+		}
+		n.queues = append(n.queues, q)
+	}
+	return n, nil
+}
+
+// Démarre les envois en tâche de fond
+func (n *Notifier) Start() {
+	for _, q := range n.queues {
+		go q.run()
+	}
+}
+
+// Prend en compte des changements d'alertes ; seuls les passages à firing
+// et resolved sont notifiés, une seule fois par état
+func (n *Notifier) Notify(changes []AlertChange) {
+	if n == nil || len(n.queues) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, change := range changes {
+		alert := change.Alert
+		if alert.State != AlertFiring && alert.State != AlertResolved {
+			continue
+		}
+		key := alertKey(alert)
+		if n.sent[key] == alert.State {
+			continue
+		}
+		if alert.State == AlertResolved && n.sent[key] == "" {
+			// jamais notifiée comme active : rien à résoudre
+			continue
+		}
+		n.sent[key] = alert.State
+		if alert.State == AlertResolved {
+			delete(n.sent, key)
+		}
+
+		first := len(n.pending[alert.Hostname]) == 0
+		n.pending[alert.Hostname] = append(n.pending[alert.Hostname], alert)
+		if first {
+			hostname := alert.Hostname
+			time.AfterFunc(n.groupWait, func() { n.flush(hostname) })
+		}
+	}
+}
+
+func (n *Notifier) flush(hostname string) {
+	n.mu.Lock()
+	alerts := n.pending[hostname]
+	delete(n.pending, hostname)
+	n.seq++
+	id := fmt.Sprintf("%s-%d-%d", hostname, time.Now().Unix(), n.seq)
+	n.mu.Unlock()
+	if len(alerts) == 0 {
+		return
+	}
+
+	// une même alerte peut changer plusieurs fois pendant l'attente : on garde la dernière
+	latest := make(map[string]Alert)
+	for _, alert := range alerts {
+		latest[alertKey(alert)] = alert
+	}
+	notification := Notification{ID: id, Hostname: hostname, SentAt: time.Now().UTC()}
+	for _, alert := range latest {
+		notification.Alerts = append(notification.Alerts, alert)
+	}
+	sort.Slice(notification.Alerts, func(i, j int) bool {
+		return notification.Alerts[i].ActiveSince.Before(notification.Alerts[j].ActiveSince)
+	})
+	for _, alert := range notification.Alerts {
+		if alert.State == AlertFiring {
+			notification.Firing = append(notification.Firing, alert)
+		} else {
+			notification.Resolved = append(notification.Resolved, alert)
+		}
+	}
+	for _, q := range n.queues {
+		q.enqueue(notification)
+	}
+}
+
+// Identifiant stable d'une alerte : règle et labels
+func alertKey(alert Alert) string {
+	keys := make([]string, 0, len(alert.Labels))
+	for k := range alert.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(alert.Rule)
+	for _, k := range keys {
+		b.WriteString("|" + k + "=" + alert.Labels[k])
+	}
+	return b.String()
+}
+
+// Écrit un fichier via un fichier temporaire renommé, pour ne jamais laisser
+// de contenu tronqué
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Webhook HTTP : JSON générique, ou format Slack / Microsoft Teams
+type webhookSink struct {
+	name   string
+	kind   string // webhook, slack, teams
+	url    string
+	body   *template.Template
+	client *http.Client
+}
+
+func newWebhookSink(name, kind, url string, body *template.Template, timeout time.Duration) *webhookSink {
+	return &webhookSink{name: name, kind: kind, url: url, body: body, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *webhookSink) Name() string { return s.name }
+
+func (s *webhookSink) Send(n Notification) error {
+	text, err := renderTemplate(s.body, n)
+	if err != nil {
+		return err
+	}
+
+	var payload interface{}
+	switch s.kind {
+	case "slack":
+		payload = map[string]interface{}{"text": text}
+	case "teams":
+		color := "2DC72D"
+		if len(n.Firing) > 0 {
+			color = "D7000C"
+		}
+		payload = map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    fmt.Sprintf("CPU Monitor: %s", n.Hostname),
+			"themeColor": color,
+			"title":      fmt.Sprintf("CPU Monitor: %s", n.Hostname),
+			"text":       strings.ReplaceAll(text, "\n", "<br>"),
+		}
+	default:
+		payload = struct {
+			Notification
+			Message string `json:"message"`
+		}{n, text}
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("réponse %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Paramètres d'envoi d'e-mail
+type SMTPConfig struct {
+	Addr     string   `json:"addr,omitempty"` // hôte:port
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// E-mail via SMTP (STARTTLS si le serveur le propose)
+type smtpSink struct {
+	name    string
+	cfg     SMTPConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+func (s *smtpSink) Name() string { return s.name }
+
+func (s *smtpSink) Send(n Notification) error {
+	subject, err := renderTemplate(s.subject, n)
+	if err != nil {
+		return err
+	}
+	body, err := renderTemplate(s.body, n)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.SentAt.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host := s.cfg.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	return smtp.SendMail(s.cfg.Addr, auth, s.cfg.From, s.cfg.To, msg.Bytes())
+}
+
+// Valeur d'en-tête sur une seule ligne (aucun CR/LF ne peut ajouter
+// d'en-tête), encodée en RFC 2047 si elle n'est pas en ASCII
+func headerValue(value string) string {
+	value = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// Fichier en ajout seul : une ligne JSON par notification
+type fileSink struct {
+	name string
+	path string
+	body *template.Template
+	mu   sync.Mutex
+}
+
+func (s *fileSink) Name() string { return s.name }
+
+func (s *fileSink) Send(n Notification) error {
+	text, err := renderTemplate(s.body, n)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(struct {
+		Notification
+		Message string `json:"message"`
+	}{n, text})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serveur SMTP minimal : accepte un message et le renvoie sur le canal
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.Fields(line + " ")[0]); verb {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 fake")
+			case "MAIL", "RCPT", "RSET", "NOOP":
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				messages <- string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestSMTPSinkHeaders(t *testing.T) {
+	addr, messages := fakeSMTPServer(t)
+	sink, err := newSink(SinkConfig{
+		Name:    "mail",
+		Type:    "smtp",
+		Subject: "🔥 {{.Hostname}} en alerte",
+		SMTP:    SMTPConfig{Addr: addr, From: "monitor@example.com", To: []string{"ops@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// un nom d'hôte piégé ne doit pas pouvoir ajouter d'en-tête
+	n := Notification{ID: "1", Hostname: "web\r\nBcc: evil@example.com\rX-Evil: 1", SentAt: time.Now()}
+	if err := sink.Send(n); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var raw string
+	select {
+	case raw = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aucun message reçu")
+	}
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("message illisible: %v\n%s", err, raw)
+	}
+	for _, header := range []string{"Bcc", "X-Evil"} {
+		if v := msg.Header.Get(header); v != "" {
+			t.Errorf("en-tête injecté %s: %q", header, v)
+		}
+	}
+	encoded := msg.Header.Get("Subject")
+	if !strings.HasPrefix(encoded, "=?utf-8?q?") {
+		t.Errorf("sujet non encodé: %q", encoded)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("sujet: %v", err)
+	}
+	if want := "🔥 web Bcc: evil@example.com X-Evil: 1 en alerte"; subject != want {
+		t.Errorf("sujet = %q, attendu %q", subject, want)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Le nom d'un canal nomme sa file sur disque : unique et sans chemin
+func TestLoadNotifierConfigSinkNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinks   string
+		wantErr string
+	}{
+		{"valides", `{"name":"ops","type":"file","path":"a.log"},{"name":"ops-mail.2","type":"file","path":"b.log"}`, ""},
+		{"nom absent", `{"type":"file","path":"a.log"}`, "name requis"},
+		{"nom en double", `{"name":"ops","type":"file","path":"a.log"},{"name":"ops","type":"file","path":"b.log"}`, "déjà utilisé"},
+		{"séparateur", `{"name":"../ops","type":"file","path":"a.log"}`, "invalide"},
+		{"remontée", `{"name":"ops..x","type":"file","path":"a.log"}`, "invalide"},
+		{"fichier caché", `{"name":".ops","type":"file","path":"a.log"}`, "invalide"},
+		{"barre oblique", `{"name":"ops/x","type":"file","path":"a.log"}`, "invalide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notify.json")
+			if err := os.WriteFile(path, []byte(`{"sinks":[`+tt.sinks+`]}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadNotifierConfig(path)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("LoadNotifierConfig: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("LoadNotifierConfig = %v, attendu %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Préfixe des jetons d'API personnels
+const APIKeyPrefix = "cpk_"
+
+// APIKey est une clé d'API personnelle ; seule l'empreinte du secret est
+// gardée (SHA-256 suffit pour un secret aléatoire de 256 bits)
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey crée une clé et renvoie le jeton complet, affiché une seule fois
+func NewAPIKey(name string) (APIKey, string) {
+	id := randomToken()[:16]
+	secret := randomToken()
+	key := APIKey{ID: id, Name: name, Hash: hashSecret(secret), CreatedAt: time.Now().UTC()}
+	return key, APIKeyPrefix + id + "." + secret
+}
+
+// ParseAPIKey découpe un jeton en identifiant et secret
+func ParseAPIKey(token string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, APIKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ".")
+}
+
+// Matches compare un secret à l'empreinte en temps constant
+func (k APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) == 1
+}
+
+// FindAPIKey renvoie le compte propriétaire d'une clé valide
+func (s *Store) FindAPIKey(token string) (User, bool) {
+	id, secret, ok := ParseAPIKey(token)
+	if !ok {
+		return User{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		for _, k := range u.APIKeys {
+			if k.ID == id && k.Matches(secret) {
+				return *u, true
+			}
+		}
+	}
+	return User{}, false
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Empreintes argon2id, au format "argon2id$v=19$m=<Kio>,t=<passes>,p=<threads>$<sel>$<clé>"
+const (
+	hashScheme   = "argon2id"
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 2
+	saltLength   = 16
+	keyLength    = 32
+)
+
+// Longueur minimale d'un mot de passe
+const MinPasswordLength = 8
+
+var ErrWeakPassword = fmt.Errorf("mot de passe trop court (%d caractères minimum)", MinPasswordLength)
+
+var errBadHash = errors.New("empreinte de mot de passe invalide")
+
+// Paramètres argon2id d'une empreinte
+type argonParams struct {
+	time, memory uint32
+	threads      uint8
+}
+
+// HashPassword calcule l'empreinte salée d'un mot de passe
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, keyLength)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$v=%d$m=%d,t=%d,p=%d$%s$%s", hashScheme, argon2.Version,
+		argonMemory, argonTime, argonThreads, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// VerifyPassword compare un mot de passe à une empreinte
+func VerifyPassword(hash, password string) bool {
+	params, salt, key, err := parseArgonHash(hash)
+	if err != nil {
+		return false
+	}
+	derived := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+func parseArgonHash(hash string) (argonParams, []byte, []byte, error) {
+	var params argonParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != hashScheme || parts[1] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, nil, nil, errBadHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, errBadHash
+	}
+	if params.time < 1 || params.threads < 1 {
+		return params, nil, nil, errBadHash
+	}
+	salt, key, err := decodeSaltKey(parts[3], parts[4])
+	return params, salt, key, err
+}
+
+func decodeSaltKey(salt, key string) ([]byte, []byte, error) {
+	enc := base64.RawStdEncoding
+	s, err := enc.DecodeString(salt)
+	if err != nil {
+		return nil, nil, errBadHash
+	}
+	k, err := enc.DecodeString(key)
+	if err != nil || len(k) == 0 {
+		return nil, nil, errBadHash
+	}
+	return s, k, nil
+}
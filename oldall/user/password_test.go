@@ -0,0 +1,33 @@
+package user
+
+import "testing"
+
+func TestPasswordHashes(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		ok       bool
+	}{
+		{"argon2id", hash, "correct horse", true},
+		{"mauvais mot de passe", hash, "wrong horse", false},
+		{"schéma inconnu", "md5$abc", "correct horse", false},
+		{"empreinte tronquée", hash[:20], "correct horse", false},
+		{"empreinte vide", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPassword(tt.hash, tt.password); got != tt.ok {
+				t.Errorf("VerifyPassword = %v, attendu %v", got, tt.ok)
+			}
+		})
+	}
+
+	if _, err := HashPassword("court"); err != ErrWeakPassword {
+		t.Errorf("HashPassword(court) = %v", err)
+	}
+}
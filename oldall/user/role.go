@@ -0,0 +1,41 @@
+package user
+
+import "fmt"
+
+// Role détermine les actions permises
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // lecture
+	RoleOperator Role = "operator" // lecture et actions sur les hôtes
+	RoleAdmin    Role = "admin"    // administration des comptes et des clés
+)
+
+// AllGroups donne accès à tous les groupes d'hôtes
+const AllGroups = "*"
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Allows indique si le rôle couvre le rôle demandé
+func (r Role) Allows(required Role) bool {
+	return r.level() >= required.level() && r.level() > 0
+}
+
+// ParseRole valide un nom de rôle
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if r.level() == 0 {
+		return "", fmt.Errorf("rôle inconnu: %q (viewer, operator ou admin)", s)
+	}
+	return r, nil
+}
@@ -0,0 +1,97 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Session ouverte après une connexion réussie
+type Session struct {
+	Token   string
+	Login   string
+	CSRF    string // à renvoyer dans X-CSRF-Token pour les requêtes qui modifient
+	Created time.Time
+	Expires time.Time
+}
+
+// CheckCSRF compare le jeton anti-CSRF en temps constant
+func (s Session) CheckCSRF(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) == 1
+}
+
+// Sessions gère les sessions en mémoire ; elles expirent après ttl
+// d'inactivité et sont perdues au redémarrage
+type Sessions struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*Session
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[string]*Session)}
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Open crée une session pour un utilisateur
+func (m *Sessions) Open(login string, now time.Time) Session {
+	s := &Session{
+		Token:   randomToken(),
+		Login:   login,
+		CSRF:    randomToken(),
+		Created: now,
+		Expires: now.Add(m.ttl),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, old := range m.sessions {
+		if now.After(old.Expires) {
+			delete(m.sessions, token)
+		}
+	}
+	m.sessions[s.Token] = s
+	return *s
+}
+
+// Get renvoie une session valide et prolonge son expiration
+func (m *Sessions) Get(token string, now time.Time) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[token]
+	if !ok {
+		return Session{}, false
+	}
+	if now.After(s.Expires) {
+		delete(m.sessions, token)
+		return Session{}, false
+	}
+	s.Expires = now.Add(m.ttl)
+	return *s, true
+}
+
+// Close ferme une session
+func (m *Sessions) Close(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, token)
+}
+
+// CloseUser ferme toutes les sessions d'un utilisateur
+func (m *Sessions) CloseUser(login string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, s := range m.sessions {
+		if s.Login == login {
+			delete(m.sessions, token)
+		}
+	}
+}
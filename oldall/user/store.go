@@ -0,0 +1,170 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrNotFound     = errors.New("utilisateur inconnu")
+	ErrExists       = errors.New("utilisateur déjà existant")
+	ErrInvalidLogin = errors.New("login invalide (lettres, chiffres, . _ -, 1 à 64 caractères)")
+)
+
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Store conserve les comptes dans un fichier JSON (permissions 0600)
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*User
+}
+
+// OpenStore charge le fichier de comptes (absent : magasin vide)
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, users: make(map[string]*User)}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Users []*User `json:"users"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, u := range file.Users {
+		s.users[u.Login] = u
+	}
+	return s, nil
+}
+
+// Len renvoie le nombre de comptes
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// Get renvoie une copie du compte
+func (s *Store) Get(login string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[login]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// List renvoie les comptes triés par login
+func (s *Store) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	return users
+}
+
+// Create ajoute un compte
+func (s *Store) Create(u *User) error {
+	if !loginPattern.MatchString(u.Login) {
+		return ErrInvalidLogin
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.Login]; ok {
+		return ErrExists
+	}
+	copied := *u
+	s.users[u.Login] = &copied
+	if err := s.saveLocked(); err != nil {
+		delete(s.users, u.Login)
+		return err
+	}
+	return nil
+}
+
+// Update applique fn à un compte puis l'enregistre
+func (s *Store) Update(login string, fn func(u *User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.users[login]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	updated := *current
+	if err := fn(&updated); err != nil {
+		return User{}, err
+	}
+	updated.Login = login
+	s.users[login] = &updated
+	if err := s.saveLocked(); err != nil {
+		s.users[login] = current
+		return User{}, err
+	}
+	return updated, nil
+}
+
+// Delete supprime un compte
+func (s *Store) Delete(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.users[login]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.users, login)
+	if err := s.saveLocked(); err != nil {
+		s.users[login] = current
+		return err
+	}
+	return nil
+}
+
+// Écriture atomique : fichier temporaire synchronisé puis renommé
+func (s *Store) saveLocked() error {
+	users := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	raw, err := json.MarshalIndent(map[string]interface{}{"users": users}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -1,6 +1,10 @@
 package user
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // User représente un utilisateur
 type User struct {
@@ -8,18 +12,31 @@ type User struct {
 	Login string // public
 	email string // privé
 	age   int    // privé
+
+	passwordHash string    // privé, voir SetPassword
+	Role         Role      // viewer, operator ou admin
+	Groups       []string  // groupes d'hôtes visibles ("*" : tous)
+	APIKeys      []APIKey  // clés d'API personnelles
+	CreatedAt    time.Time // date de création du compte
 }
 
 // New crée un nouvel utilisateur et retourne un pointeur vers User
 func New(name, login, email string, age int) *User {
 	return &User{
-		Name:  name,
-		Login: login,
-		email: email,
-		age:   age,
+		Name:      name,
+		Login:     login,
+		email:     email,
+		age:       age,
+		Role:      RoleViewer,
+		CreatedAt: time.Now().UTC(),
 	}
 }
 
+// IsAdmin indique l'accès à l'administration
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
 // UpdateName met à jour le nom de l'utilisateur
 func (u *User) UpdateName(name string) {
 	u.Name = name
@@ -43,7 +60,93 @@ func (u *User) UpdateAge(age int) {
 	u.age = age
 }
 
+// --- GESTION MOT DE PASSE ---
+
+// SetPassword remplace le mot de passe (seule son empreinte est gardée)
+func (u *User) SetPassword(password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.passwordHash = hash
+	return nil
+}
+
+// CheckPassword vérifie un mot de passe en temps constant
+func (u *User) CheckPassword(password string) bool {
+	return u.passwordHash != "" && VerifyPassword(u.passwordHash, password)
+}
+
 // Retourne toutes les infos
 func (u *User) GetInfo() string {
 	return fmt.Sprintf("%s (%s), %s, âge: %d", u.Name, u.Login, u.email, u.age)
 }
+
+// Forme persistée d'un utilisateur (avec l'empreinte du mot de passe)
+type record struct {
+	Name         string    `json:"name"`
+	Login        string    `json:"login"`
+	Email        string    `json:"email,omitempty"`
+	Age          int       `json:"age,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         Role      `json:"role"`
+	Groups       []string  `json:"groups,omitempty"`
+	APIKeys      []APIKey  `json:"api_keys,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (u *User) MarshalJSON() ([]byte, error) {
+	return json.Marshal(record{
+		Name:         u.Name,
+		Login:        u.Login,
+		Email:        u.email,
+		Age:          u.age,
+		PasswordHash: u.passwordHash,
+		Role:         u.Role,
+		Groups:       u.Groups,
+		APIKeys:      u.APIKeys,
+		CreatedAt:    u.CreatedAt,
+	})
+}
+
+func (u *User) UnmarshalJSON(b []byte) error {
+	var r record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	role := r.Role
+	if role == "" {
+		role = RoleViewer
+	}
+	*u = User{
+		Name:         r.Name,
+		Login:        r.Login,
+		email:        r.Email,
+		age:          r.Age,
+		passwordHash: r.PasswordHash,
+		Role:         role,
+		Groups:       r.Groups,
+		APIKeys:      r.APIKeys,
+		CreatedAt:    r.CreatedAt,
+	}
+	return nil
+}
+
+// Public est la vue d'un utilisateur exposée par l'API (sans secrets)
+type Public struct {
+	Name      string    `json:"name"`
+	Login     string    `json:"login"`
+	Email     string    `json:"email,omitempty"`
+	Age       int       `json:"age,omitempty"`
+	Role      Role      `json:"role"`
+	Groups    []string  `json:"groups"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u *User) Public() Public {
+	groups := u.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return Public{u.Name, u.Login, u.email, u.age, u.Role, groups, u.CreatedAt}
+}
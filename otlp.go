@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
+)
+
+// Sous-ensemble de ExportMetricsServiceRequest utile au serveur ; les mêmes
+// structures servent au JSON (noms lowerCamelCase) et au décodeur protobuf
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name  string          `json:"name"`
+	Gauge *otlpNumberData `json:"gauge,omitempty"`
+	Sum   *otlpNumberData `json:"sum,omitempty"`
+}
+
+type otlpNumberData struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano otlpInt        `json:"timeUnixNano"`
+	AsDouble     *float64       `json:"asDouble,omitempty"`
+	AsInt        *otlpInt       `json:"asInt,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *otlpInt `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Entier 64 bits : chaîne en JSON OTLP, nombre accepté aussi
+type otlpInt int64
+
+func (n *otlpInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		u, uerr := strconv.ParseUint(s, 10, 64)
+		if uerr != nil {
+			return err
+		}
+		v = int64(u)
+	}
+	*n = otlpInt(v)
+	return nil
+}
+
+func (v otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+func (p otlpDataPoint) value() (float64, bool) {
+	switch {
+	case p.AsDouble != nil:
+		return *p.AsDouble, !math.IsNaN(*p.AsDouble)
+	case p.AsInt != nil:
+		return float64(*p.AsInt), true
+	}
+	return 0, false
+}
+
+func (d *otlpNumberData) points() []otlpDataPoint {
+	if d == nil {
+		return nil
+	}
+	return d.DataPoints
+}
+
+func otlpAttrs(kvs []otlpKeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	return attrs
+}
+
+// Premier attribut présent parmi plusieurs noms (conventions sémantiques successives)
+func firstAttr(attrs map[string]string, names ...string) (string, bool) {
+	for _, name := range names {
+		if v, ok := attrs[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Convertit une requête OTLP en un instantané par host.name.
+// system.cpu.utilization et process.cpu.utilization sont des ratios 0..1 ;
+// pour les processus, le ratio est rapporté à l'ensemble des CPU de l'hôte.
+func snapshotsFromOTLP(req otlpRequest, now time.Time) []SystemData {
+	type hostBatch struct {
+		data         SystemData
+		busy         map[int]float64 // somme des états non idle
+		idle         map[int]float64
+		hasIdle      map[int]bool
+		procs        map[int32]ProcessInfo
+		latest       time.Time
+		hasCPU       bool
+		hasProcesses bool
+	}
+	hosts := make(map[string]*hostBatch)
+
+	for _, rm := range req.ResourceMetrics {
+		resource := otlpAttrs(rm.Resource.Attributes)
+		hostname := resource["host.name"]
+		if hostname == "" {
+			continue
+		}
+		h, ok := hosts[hostname]
+		if !ok {
+			h = &hostBatch{
+				busy:    make(map[int]float64),
+				idle:    make(map[int]float64),
+				hasIdle: make(map[int]bool),
+				procs:   make(map[int32]ProcessInfo),
+			}
+			h.data.Hostname = hostname
+			hosts[hostname] = h
+		}
+		if osType := resource["os.type"]; osType != "" {
+			h.data.OS = osType
+		}
+		if platform, ok := firstAttr(resource, "os.name", "os.description"); ok {
+			h.data.Platform = platform
+		}
+
+		// Ressource de processus (récepteur hostmetrics, scraper process)
+		var proc *ProcessInfo
+		if pid, err := strconv.Atoi(resource["process.pid"]); err == nil {
+			p := h.procs[int32(pid)]
+			p.PID = int32(pid)
+			p.Name, _ = firstAttr(resource, "process.executable.name", "process.command")
+			p.CmdLine = resource["process.command_line"]
+			p.Username = resource["process.owner"]
+			proc = &p
+		}
+
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				dataPoints := append(m.Gauge.points(), m.Sum.points()...)
+				for _, dp := range dataPoints {
+					value, ok := dp.value()
+					if !ok {
+						continue
+					}
+					if at := time.Unix(0, int64(dp.TimeUnixNano)).UTC(); dp.TimeUnixNano > 0 && at.After(h.latest) {
+						h.latest = at
+					}
+					attrs := otlpAttrs(dp.Attributes)
+					state, _ := firstAttr(attrs, "cpu.mode", "system.cpu.state", "state")
+
+					switch m.Name {
+					case "system.cpu.utilization":
+						cpu, ok := firstAttr(attrs, "cpu.logical_number", "system.cpu.logical_number", "cpu")
+						if !ok {
+							continue
+						}
+						core, err := strconv.Atoi(strings.TrimPrefix(cpu, "cpu"))
+						if err != nil {
+							continue
+						}
+						h.hasCPU = true
+						if state == "idle" {
+							h.idle[core] = value
+							h.hasIdle[core] = true
+						} else {
+							h.busy[core] += value
+						}
+					case "process.cpu.utilization":
+						if proc != nil {
+							proc.CPUPercent += value * 100
+						}
+					case "process.memory.utilization":
+						if proc != nil {
+							proc.MemPercent = float32(value * 100)
+						}
+					case "process.threads":
+						if proc != nil {
+							proc.NumThreads = int32(value)
+						}
+					}
+				}
+			}
+		}
+		if proc != nil {
+			h.hasProcesses = true
+			h.procs[proc.PID] = *proc
+		}
+	}
+
+	var snapshots []SystemData
+	for _, h := range hosts {
+		if !h.hasCPU && !h.hasProcesses {
+			continue
+		}
+		if h.latest.IsZero() {
+			h.latest = now
+		}
+		data := h.data
+		data.CollectedAt = h.latest.Format(time.RFC3339)
+		data.UserAgent = "otlp"
+
+		cores := make(map[int]CPUClientCoreData)
+		for core := range h.busy {
+			cores[core] = CPUClientCoreData{}
+		}
+		for core := range h.idle {
+			cores[core] = CPUClientCoreData{}
+		}
+		for core := range cores {
+			// 1 - idle quand l'état idle est fourni, sinon somme des autres états
+			ratio := h.busy[core]
+			if h.hasIdle[core] {
+				ratio = 1 - h.idle[core]
+			}
+			cores[core] = CPUClientCoreData{
+				Core:       core,
+				CPUPercent: math.Max(0, math.Min(100, ratio*100)),
+				Timestamp:  data.CollectedAt,
+			}
+		}
+		data.CoreData = sortedCores(cores)
+		data.Processes = sortedProcesses(h.procs)
+		snapshots = append(snapshots, mergeWithKnown(data, h.hasCPU, h.hasProcesses))
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Hostname < snapshots[j].Hostname })
+	return snapshots
+}
+
+// Récepteur OTLP/HTTP (protobuf ou JSON)
+func handleOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	if r.Method != http.MethodPost {
+		writeOTLPError(w, isJSON, http.StatusMethodNotAllowed, "Méthode non autorisée")
+		return
+	}
+
+	counters.ingestRequests.Add(1)
+	if err := limiter.AllowGlobal(time.Now()); err != nil {
+		setRetryAfter(w, err)
+		writeOTLPError(w, isJSON, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	limitBody(w, r)
+	body := &countingReader{r: r.Body}
+	defer func() { counters.ingestBytes.Add(uint64(body.n)) }()
+
+	reader, err := contentReader(r, body)
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		writeOTLPError(w, isJSON, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		writeOTLPError(w, isJSON, bodyErrorStatus(err), err.Error())
+		return
+	}
+
+	var req otlpRequest
+	if isJSON {
+		err = json.Unmarshal(raw, &req)
+	} else {
+		req, err = decodeOTLPProto(raw)
+	}
+	if err != nil {
+		counters.decodeErrors.Add(1)
+		log.Printf("❌ Erreur décodage OTLP: %v", err)
+		writeOTLPError(w, isJSON, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	snapshots := snapshotsFromOTLP(req, now)
+	if status, err := checkSnapshots(r, snapshots, now); err != nil {
+		if isJSON {
+			writeIngestError(w, status, err)
+		} else {
+			setRetryAfter(w, err)
+			writeOTLPError(w, isJSON, status, err.Error())
+		}
+		return
+	}
+	if err := ingestQueue.Do(func() error { return ingestBatch(snapshots, sourceIP(r)) }); err != nil {
+		setRetryAfter(w, err)
+		writeOTLPError(w, isJSON, throttleStatus(err, http.StatusInternalServerError), "données non enregistrées: "+err.Error())
+		return
+	}
+
+	// ExportMetricsServiceResponse vide
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Erreur au format attendu par l'exportateur (google.rpc.Status en protobuf)
+func writeOTLPError(w http.ResponseWriter, isJSON bool, status int, message string) {
+	if isJSON {
+		writeJSONError(w, status, message)
+		return
+	}
+	var enc protocol.WireEncoder
+	enc.VarintField(1, uint64(grpcCode(status)))
+	enc.BytesField(2, []byte(message))
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(status)
+	w.Write(enc.Buf)
+}
+
+// Code gRPC correspondant au statut HTTP
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return 3 // INVALID_ARGUMENT
+	case http.StatusForbidden:
+		return 7 // PERMISSION_DENIED
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return 8 // RESOURCE_EXHAUSTED
+	case http.StatusServiceUnavailable:
+		return 14 // UNAVAILABLE
+	case http.StatusMethodNotAllowed:
+		return 12 // UNIMPLEMENTED
+	}
+	return 13 // INTERNAL
+}
+
+// Décodage protobuf de ExportMetricsServiceRequest (champs utiles seulement)
+func decodeOTLPProto(buf []byte) (otlpRequest, error) {
+	var req otlpRequest
+	err := protocol.DecodeMessage(buf, func(r *protocol.WireReader, field, wireType int) error {
+		if field != 1 || wireType != protocol.WireBytes {
+			return r.Skip(wireType)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		rm, err := decodeResourceMetrics(b)
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		return err
+	})
+	return req, err
+}
+
+func decodeResourceMetrics(buf []byte) (otlpResourceMetrics, error) {
+	var rm otlpResourceMetrics
+	err := protocol.DecodeMessage(buf, func(r *protocol.WireReader, field, wireType int) error {
+		switch field {
+		case 1: // resource
+			return protocol.SubMessage(r, wireType, func(b []byte) error {
+				return protocol.DecodeMessage(b, func(r *protocol.WireReader, field, wireType int) error {
+					if field != 1 {
+						return r.Skip(wireType)
+					}
+					return protocol.SubMessage(r, wireType, func(b []byte) error {
+						kv, err := decodeKeyValue(b)
+						rm.Resource.Attributes = append(rm.Resource.Attributes, kv)
+						return err
+					})
+				})
+			})
+		case 2: // scope_metrics
+			return protocol.SubMessage(r, wireType, func(b []byte) error {
+				var sm otlpScopeMetrics
+				err := protocol.DecodeMessage(b, func(r *protocol.WireReader, field, wireType int) error {
+					if field != 2 {
+						return r.Skip(wireType)
+					}
+					return protocol.SubMessage(r, wireType, func(b []byte) error {
+						m, err := decodeMetric(b)
+						sm.Metrics = append(sm.Metrics, m)
+						return err
+					})
+				})
+				rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+				return err
+			})
+		}
+		return r.Skip(wireType)
+	})
+	return rm, err
+}
+
+func decodeMetric(buf []byte) (otlpMetric, error) {
+	var m otlpMetric
+	err := protocol.DecodeMessage(buf, func(r *protocol.WireReader, field, wireType int) error {
+		switch field {
+		case 1: // name
+			if wireType != protocol.WireBytes {
+				return r.Skip(wireType)
+			}
+			b, err := r.Bytes()
+			m.Name = string(b)
+			return err
+		case 5, 7: // gauge, sum
+			data := &otlpNumberData{}
+			if field == 5 {
+				m.Gauge = data
+			} else {
+				m.Sum = data
+			}
+			return protocol.SubMessage(r, wireType, func(b []byte) error {
+				return protocol.DecodeMessage(b, func(r *protocol.WireReader, field, wireType int) error {
+					if field != 1 {
+						return r.Skip(wireType)
+					}
+					return protocol.SubMessage(r, wireType, func(b []byte) error {
+						dp, err := decodeDataPoint(b)
+						data.DataPoints = append(data.DataPoints, dp)
+						return err
+					})
+				})
+			})
+		}
+		return r.Skip(wireType)
+	})
+	return m, err
+}
+
+func decodeDataPoint(buf []byte) (otlpDataPoint, error) {
+	var dp otlpDataPoint
+	err := protocol.DecodeMessage(buf, func(r *protocol.WireReader, field, wireType int) error {
+		switch {
+		case field == 7: // attributes
+			return protocol.SubMessage(r, wireType, func(b []byte) error {
+				kv, err := decodeKeyValue(b)
+				dp.Attributes = append(dp.Attributes, kv)
+				return err
+			})
+		case field == 3 && wireType == protocol.WireFixed64: // time_unix_nano
+			v, err := r.Fixed64()
+			dp.TimeUnixNano = otlpInt(v)
+			return err
+		case field == 4 && wireType == protocol.WireFixed64: // as_double
+			v, err := r.Double()
+			dp.AsDouble = &v
+			return err
+		case field == 6 && wireType == protocol.WireFixed64: // as_int (sfixed64)
+			v, err := r.Fixed64()
+			n := otlpInt(int64(v))
+			dp.AsInt = &n
+			return err
+		}
+		return r.Skip(wireType)
+	})
+	return dp, err
+}
+
+func decodeKeyValue(buf []byte) (otlpKeyValue, error) {
+	var kv otlpKeyValue
+	err := protocol.DecodeMessage(buf, func(r *protocol.WireReader, field, wireType int) error {
+		switch {
+		case field == 1 && wireType == protocol.WireBytes:
+			b, err := r.Bytes()
+			kv.Key = string(b)
+			return err
+		case field == 2:
+			return protocol.SubMessage(r, wireType, func(b []byte) error {
+				return protocol.DecodeMessage(b, func(r *protocol.WireReader, field, wireType int) error {
+					switch {
+					case field == 1 && wireType == protocol.WireBytes:
+						b, err := r.Bytes()
+						s := string(b)
+						kv.Value.StringValue = &s
+						return err
+					case field == 2 && wireType == protocol.WireVarint:
+						v, err := r.Varint()
+						bv := v != 0
+						kv.Value.BoolValue = &bv
+						return err
+					case field == 3 && wireType == protocol.WireVarint:
+						v, err := r.Varint()
+						n := otlpInt(int64(v))
+						kv.Value.IntValue = &n
+						return err
+					case field == 4 && wireType == protocol.WireFixed64:
+						v, err := r.Double()
+						kv.Value.DoubleValue = &v
+						return err
+					}
+					return r.Skip(wireType)
+				})
+			})
+		}
+		return r.Skip(wireType)
+	})
+	return kv, err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Ressource OTLP JSON avec ses attributs et ses métriques
+func otlpResourceJSON(attrs, metrics string) string {
+	return `{"resource":{"attributes":[` + attrs + `]},"scopeMetrics":[{"metrics":[` + metrics + `]}]}`
+}
+
+func otlpAttrJSON(key, value string) string {
+	return `{"key":"` + key + `","value":{"stringValue":"` + value + `"}}`
+}
+
+func TestSnapshotsFromOTLP(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+	registry = NewRegistry()
+
+	now := time.Date(2025, 9, 3, 12, 0, 0, 0, time.UTC)
+	host := otlpAttrJSON("host.name", "web-1")
+	cpuPoint := func(core, state string, value float64) string {
+		v, _ := json.Marshal(value)
+		return `{"attributes":[` + otlpAttrJSON("cpu", core) + `,` + otlpAttrJSON("state", state) + `],"timeUnixNano":"1756900740000000000","asDouble":` + string(v) + `}`
+	}
+	tests := []struct {
+		name      string
+		resources []string
+		check     func(t *testing.T, got []SystemData)
+	}{
+		{"état idle fourni", []string{otlpResourceJSON(host+`,`+otlpAttrJSON("os.type", "linux"),
+			`{"name":"system.cpu.utilization","gauge":{"dataPoints":[`+
+				cpuPoint("cpu0", "user", 0.2)+`,`+cpuPoint("cpu0", "system", 0.1)+`,`+cpuPoint("cpu0", "idle", 0.6)+`]}}`)},
+			func(t *testing.T, got []SystemData) {
+				if len(got) != 1 || got[0].OS != "linux" {
+					t.Fatalf("instantanés = %+v", got)
+				}
+				// 1 - idle, pas la somme des autres états
+				if cores := got[0].CoreData; len(cores) != 1 || cores[0].CPUPercent != 40 {
+					t.Errorf("cœurs = %+v, attendu 40 %%", cores)
+				}
+				if got[0].CollectedAt != "2025-09-03T11:59:00Z" {
+					t.Errorf("CollectedAt = %q, attendu timeUnixNano", got[0].CollectedAt)
+				}
+			}},
+		{"sans état idle", []string{otlpResourceJSON(host,
+			`{"name":"system.cpu.utilization","sum":{"dataPoints":[`+
+				cpuPoint("cpu1", "user", 0.25)+`,`+cpuPoint("cpu1", "system", 0.05)+`,`+cpuPoint("cpu0", "user", 2)+`]}}`)},
+			func(t *testing.T, got []SystemData) {
+				cores := got[0].CoreData
+				if len(cores) != 2 || cores[0].Core != 0 || cores[0].CPUPercent != 100 || cores[1].CPUPercent != 30 {
+					t.Errorf("cœurs = %+v, attendu [100 30] (somme bornée)", cores)
+				}
+			}},
+		{"ressource de processus", []string{otlpResourceJSON(
+			host+`,`+otlpAttrJSON("process.pid", "42")+`,`+otlpAttrJSON("process.executable.name", "nginx")+`,`+otlpAttrJSON("process.owner", "www"),
+			`{"name":"process.cpu.utilization","gauge":{"dataPoints":[{"attributes":[`+otlpAttrJSON("state", "user")+`],"asDouble":0.02},{"attributes":[`+otlpAttrJSON("state", "system")+`],"asDouble":0.01}]}},`+
+				`{"name":"process.memory.utilization","gauge":{"dataPoints":[{"asDouble":0.5}]}},`+
+				`{"name":"process.threads","sum":{"dataPoints":[{"asInt":"8"}]}}`)},
+			func(t *testing.T, got []SystemData) {
+				procs := got[0].Processes
+				if len(procs) != 1 {
+					t.Fatalf("processus = %+v", procs)
+				}
+				p := procs[0]
+				if p.PID != 42 || p.Name != "nginx" || p.Username != "www" || p.NumThreads != 8 || p.MemPercent != 50 || p.CPUPercent < 2.99 || p.CPUPercent > 3.01 {
+					t.Errorf("processus = %+v", p)
+				}
+				if len(got[0].CoreData) != 0 {
+					t.Errorf("cœurs = %+v", got[0].CoreData)
+				}
+				// sans horodatage, l'heure de réception
+				if got[0].CollectedAt != now.Format(time.RFC3339) {
+					t.Errorf("CollectedAt = %q", got[0].CollectedAt)
+				}
+			}},
+		{"sans host.name", []string{otlpResourceJSON(otlpAttrJSON("service.name", "web"),
+			`{"name":"system.cpu.utilization","gauge":{"dataPoints":[`+cpuPoint("cpu0", "idle", 0.5)+`]}}`)},
+			func(t *testing.T, got []SystemData) {
+				if len(got) != 0 {
+					t.Errorf("instantanés = %+v", got)
+				}
+			}},
+		{"métriques inconnues", []string{otlpResourceJSON(host,
+			`{"name":"system.memory.usage","sum":{"dataPoints":[{"asInt":"1024"}]}}`)},
+			func(t *testing.T, got []SystemData) {
+				if len(got) != 0 {
+					t.Errorf("instantanés = %+v", got)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req otlpRequest
+			body := `{"resourceMetrics":[` + strings.Join(tt.resources, ",") + `]}`
+			if err := json.Unmarshal([]byte(body), &req); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, snapshotsFromOTLP(req, now))
+		})
+	}
+}
+
+func TestHandleOTLPMetricsErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"méthode", http.MethodGet, "application/json", "", http.StatusMethodNotAllowed},
+		{"JSON invalide", http.MethodPost, "application/json", `{"resourceMetrics":`, http.StatusBadRequest},
+		{"protobuf tronqué", http.MethodPost, "application/x-protobuf", "\x0a\x05ab", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/metrics", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			handleOTLPMetrics(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("statut %d, attendu %d", rec.Code, tt.want)
+			}
+			// l'erreur est rendue dans le format de la requête
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, strings.Split(tt.contentType, ";")[0]) {
+				t.Errorf("Content-Type = %q, attendu %q", ct, tt.contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Nombre maximal de processus renvoyés par page
+const maxProcessLimit = 1000
+
+// Filtres, tri et pagination de /api/processes et /api/processes/search
+type ProcessQuery struct {
+	Sort      string
+	User      string
+	Status    string
+	Name      *regexp.Regexp
+	CmdLine   string // sous-chaîne
+	CmdLineRe *regexp.Regexp
+	MinCPU    float64
+	MinMem    float64
+	Limit     int
+	Offset    int
+	Stats     bool
+}
+
+// Lit les paramètres de requête ; les valeurs invalides sont refusées
+func parseProcessQuery(values url.Values) (ProcessQuery, error) {
+	q := ProcessQuery{
+		Sort:   values.Get("sort"),
+		User:   values.Get("user"),
+		Status: values.Get("status"),
+		Limit:  maxProcessLimit,
+		Stats:  values.Get("stats") == "1" || values.Get("stats") == "true",
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = "cpu"
+	case "cpu", "mem", "threads", "age":
+	default:
+		return q, fmt.Errorf("sort doit valoir cpu, mem, threads ou age")
+	}
+	if name := values.Get("name"); name != "" {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return q, fmt.Errorf("name: expression régulière invalide")
+		}
+		q.Name = re
+	}
+	if v := values.Get("cmdline_regex"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return q, fmt.Errorf("cmdline_regex: expression régulière invalide")
+		}
+		q.CmdLineRe = re
+	}
+	q.CmdLine = values.Get("cmdline")
+	for param, target := range map[string]*float64{"min_cpu": &q.MinCPU, "min_mem": &q.MinMem} {
+		if v := values.Get(param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return q, fmt.Errorf("%s doit être un nombre positif", param)
+			}
+			*target = f
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxProcessLimit {
+			return q, fmt.Errorf("limit doit être entre 1 et %d", maxProcessLimit)
+		}
+		q.Limit = n
+	}
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("offset doit être positif")
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+// Indique si un processus passe les filtres
+func (q ProcessQuery) Match(proc ProcessInfo) bool {
+	if q.User != "" && proc.Username != q.User {
+		return false
+	}
+	if q.Status != "" && processState(proc.Status) != processState(q.Status) {
+		return false
+	}
+	if q.Name != nil && !q.Name.MatchString(proc.Name) {
+		return false
+	}
+	if q.CmdLine != "" && !strings.Contains(proc.CmdLine, q.CmdLine) {
+		return false
+	}
+	if q.CmdLineRe != nil && !q.CmdLineRe.MatchString(proc.CmdLine) {
+		return false
+	}
+	if proc.CPUPercent < q.MinCPU || float64(proc.MemPercent) < q.MinMem {
+		return false
+	}
+	return true
+}
+
+// Filtre puis trie ; la pagination est appliquée par Page
+func (q ProcessQuery) Apply(processes []ProcessInfo) []ProcessInfo {
+	filtered := make([]ProcessInfo, 0, len(processes))
+	for _, proc := range processes {
+		if q.Match(proc) {
+			filtered = append(filtered, proc)
+		}
+	}
+
+	var less func(a, b ProcessInfo) bool
+	switch q.Sort {
+	case "mem":
+		less = func(a, b ProcessInfo) bool { return a.MemPercent > b.MemPercent }
+	case "threads":
+		less = func(a, b ProcessInfo) bool { return a.NumThreads > b.NumThreads }
+	case "age":
+		// les plus anciens d'abord
+		less = func(a, b ProcessInfo) bool { return a.CreateTime < b.CreateTime }
+	default:
+		less = func(a, b ProcessInfo) bool { return a.CPUPercent > b.CPUPercent }
+	}
+	sort.SliceStable(filtered, func(i, j int) bool { return less(filtered[i], filtered[j]) })
+	return filtered
+}
+
+// Découpe la page demandée
+func (q ProcessQuery) Page(processes []ProcessInfo) []ProcessInfo {
+	if q.Offset >= len(processes) {
+		return []ProcessInfo{}
+	}
+	end := q.Offset + q.Limit
+	if end > len(processes) {
+		end = len(processes)
+	}
+	return processes[q.Offset:end]
+}
+
+// Processus correspondants d'un hôte
+type HostProcessMatches struct {
+	CollectedAt string        `json:"collected_at"`
+	Stale       bool          `json:"stale"`
+	Processes   []ProcessInfo `json:"processes"`
+	Total       int           `json:"total"`
+}
+
+// Recherche sur tous les hôtes du registre ; limit/offset s'appliquent par hôte
+func searchProcesses(clients map[string]SystemData, metas map[string]HostMeta, q ProcessQuery) map[string]HostProcessMatches {
+	results := make(map[string]HostProcessMatches)
+	for hostname, data := range clients {
+		matches := q.Apply(data.Processes)
+		if len(matches) == 0 {
+			continue
+		}
+		results[hostname] = HostProcessMatches{
+			CollectedAt: data.CollectedAt,
+			Stale:       metas[hostname].Stale,
+			Processes:   q.Page(matches),
+			Total:       len(matches),
+		}
+	}
+	return results
+}
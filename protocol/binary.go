@@ -0,0 +1,317 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encodage binaire (protobuf) d'un instantané, à partir de la version 1 :
+//
+//	message SystemData {
+//	  uint32 schema_version = 1;
+//	  string user_agent = 2;
+//	  CPUInfo cpu_info = 3;
+//	  repeated CoreData core_data = 4;
+//	  repeated ProcessInfo processes = 5;
+//	  string hostname = 6;
+//	  string os = 7;
+//	  string platform = 8;
+//	  string collected_at = 9;
+//	  uint64 sequence = 10;              // version 2
+//	  uint64 base_sequence = 11;         // version 2
+//	  ProcessDelta process_delta = 12;   // version 2
+//	}
+//	message CPUInfo { string vendor = 1; string family = 2; string model = 3; string mhz = 4; string cache_size = 5; }
+//	message CoreData { int32 core = 1; double cpu_percent = 2; string timestamp = 3; }
+//	message ProcessInfo {
+//	  int32 pid = 1; string name = 2; double cpu_percent = 3; float memory_percent = 4;
+//	  string status = 5; string username = 6; int64 create_time = 7; string cmdline = 8; int32 num_threads = 9;
+//	}
+//	message ProcessDelta { repeated ProcessInfo upserted = 1; repeated int32 removed = 2 [packed = true]; }
+
+func stringField(e *WireEncoder, field int, s string) {
+	if s != "" {
+		e.BytesField(field, []byte(s))
+	}
+}
+
+// Les entiers signés sont encodés en varint sur 64 bits, comme int32/int64 en protobuf
+func intField(e *WireEncoder, field int, v int64) {
+	if v != 0 {
+		e.VarintField(field, uint64(v))
+	}
+}
+
+func doubleField(e *WireEncoder, field int, v float64) {
+	if v != 0 {
+		e.DoubleField(field, v)
+	}
+}
+
+// Encode un instantané en protobuf dans la version demandée (1 ou plus)
+func MarshalBinary(data SystemData, version int) ([]byte, error) {
+	if version < 1 || version > CurrentVersion {
+		return nil, fmt.Errorf("%w en protobuf: %d", ErrUnsupportedVersion, version)
+	}
+	if version < 2 && data.IsDelta() {
+		return nil, fmt.Errorf("delta impossible en version %d", version)
+	}
+	var e WireEncoder
+	e.VarintField(1, uint64(version))
+	stringField(&e, 2, data.UserAgent)
+	e.MessageField(3, func(e *WireEncoder) {
+		stringField(e, 1, data.CPUInfo.VendorID)
+		stringField(e, 2, data.CPUInfo.Family)
+		stringField(e, 3, data.CPUInfo.Model)
+		stringField(e, 4, data.CPUInfo.MHz)
+		stringField(e, 5, data.CPUInfo.CacheSize)
+	})
+	for _, core := range data.CoreData {
+		e.MessageField(4, func(e *WireEncoder) {
+			intField(e, 1, int64(core.Core))
+			doubleField(e, 2, core.CPUPercent)
+			stringField(e, 3, core.Timestamp)
+		})
+	}
+	for _, p := range data.Processes {
+		e.MessageField(5, func(e *WireEncoder) { encodeProcessInfo(e, p) })
+	}
+	stringField(&e, 6, data.Hostname)
+	stringField(&e, 7, data.OS)
+	stringField(&e, 8, data.Platform)
+	stringField(&e, 9, data.CollectedAt)
+	if version >= 2 {
+		intField(&e, 10, int64(data.Sequence))
+		intField(&e, 11, int64(data.BaseSequence))
+		if d := data.ProcessDelta; d != nil {
+			e.MessageField(12, func(e *WireEncoder) {
+				for _, p := range d.Upserted {
+					e.MessageField(1, func(e *WireEncoder) { encodeProcessInfo(e, p) })
+				}
+				if len(d.Removed) > 0 {
+					var packed []byte
+					for _, pid := range d.Removed {
+						packed = binary.AppendUvarint(packed, uint64(int64(pid)))
+					}
+					e.BytesField(2, packed)
+				}
+			})
+		}
+	}
+	return e.Buf, nil
+}
+
+func encodeProcessInfo(e *WireEncoder, p ProcessInfo) {
+	intField(e, 1, int64(p.PID))
+	stringField(e, 2, p.Name)
+	doubleField(e, 3, p.CPUPercent)
+	if p.MemPercent != 0 {
+		e.FloatField(4, p.MemPercent)
+	}
+	stringField(e, 5, p.Status)
+	stringField(e, 6, p.Username)
+	intField(e, 7, p.CreateTime)
+	stringField(e, 8, p.CmdLine)
+	intField(e, 9, int64(p.NumThreads))
+}
+
+// Lecteurs typés d'un champ, vérifiant le type de fil
+func readString(r *WireReader, wireType int, dst *string) error {
+	if wireType != WireBytes {
+		return r.Skip(wireType)
+	}
+	b, err := r.Bytes()
+	*dst = string(b)
+	return err
+}
+
+func readInt(r *WireReader, wireType int) (int64, error) {
+	if wireType != WireVarint {
+		return 0, r.Skip(wireType)
+	}
+	v, err := r.Varint()
+	return int64(v), err
+}
+
+func readDouble(r *WireReader, wireType int, dst *float64) error {
+	if wireType != WireFixed64 {
+		return r.Skip(wireType)
+	}
+	v, err := r.Double()
+	*dst = v
+	return err
+}
+
+// Décode un instantané protobuf ; les champs inconnus sont ignorés
+func UnmarshalBinary(buf []byte, data *SystemData) error {
+	var decoded SystemData
+	err := DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		switch field {
+		case 1:
+			v, err := readInt(r, wireType)
+			decoded.SchemaVersion = int(v)
+			return err
+		case 2:
+			return readString(r, wireType, &decoded.UserAgent)
+		case 3:
+			return SubMessage(r, wireType, func(b []byte) error {
+				return decodeCPUInfo(b, &decoded.CPUInfo)
+			})
+		case 4:
+			return SubMessage(r, wireType, func(b []byte) error {
+				core, err := decodeCoreData(b)
+				decoded.CoreData = append(decoded.CoreData, core)
+				return err
+			})
+		case 5:
+			return SubMessage(r, wireType, func(b []byte) error {
+				p, err := decodeProcessInfo(b)
+				decoded.Processes = append(decoded.Processes, p)
+				return err
+			})
+		case 6:
+			return readString(r, wireType, &decoded.Hostname)
+		case 7:
+			return readString(r, wireType, &decoded.OS)
+		case 8:
+			return readString(r, wireType, &decoded.Platform)
+		case 9:
+			return readString(r, wireType, &decoded.CollectedAt)
+		case 10:
+			v, err := readInt(r, wireType)
+			decoded.Sequence = uint64(v)
+			return err
+		case 11:
+			v, err := readInt(r, wireType)
+			decoded.BaseSequence = uint64(v)
+			return err
+		case 12:
+			return SubMessage(r, wireType, func(b []byte) error {
+				d, err := decodeProcessDelta(b)
+				decoded.ProcessDelta = &d
+				return err
+			})
+		}
+		return r.Skip(wireType)
+	})
+	if err != nil {
+		return err
+	}
+	// pas de format binaire en version 0
+	if decoded.SchemaVersion < 1 || decoded.SchemaVersion > CurrentVersion {
+		return fmt.Errorf("%w: %d (acceptées en protobuf: 1-%d)", ErrUnsupportedVersion, decoded.SchemaVersion, CurrentVersion)
+	}
+	dropLaterFields(&decoded)
+	*data = decoded
+	return nil
+}
+
+func decodeProcessDelta(buf []byte) (ProcessDelta, error) {
+	var d ProcessDelta
+	err := DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		switch {
+		case field == 1:
+			return SubMessage(r, wireType, func(b []byte) error {
+				p, err := decodeProcessInfo(b)
+				d.Upserted = append(d.Upserted, p)
+				return err
+			})
+		case field == 2 && wireType == WireVarint:
+			v, err := r.Varint()
+			d.Removed = append(d.Removed, int32(v))
+			return err
+		case field == 2 && wireType == WireBytes:
+			// forme compacte (packed), par défaut en proto3
+			b, err := r.Bytes()
+			if err != nil {
+				return err
+			}
+			packed := NewWireReader(b)
+			for !packed.Done() {
+				v, err := packed.Varint()
+				if err != nil {
+					return err
+				}
+				d.Removed = append(d.Removed, int32(v))
+			}
+			return nil
+		}
+		return r.Skip(wireType)
+	})
+	return d, err
+}
+
+func decodeCPUInfo(buf []byte, info *CPUInfo) error {
+	return DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		switch field {
+		case 1:
+			return readString(r, wireType, &info.VendorID)
+		case 2:
+			return readString(r, wireType, &info.Family)
+		case 3:
+			return readString(r, wireType, &info.Model)
+		case 4:
+			return readString(r, wireType, &info.MHz)
+		case 5:
+			return readString(r, wireType, &info.CacheSize)
+		}
+		return r.Skip(wireType)
+	})
+}
+
+func decodeCoreData(buf []byte) (CPUClientCoreData, error) {
+	var core CPUClientCoreData
+	err := DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		switch field {
+		case 1:
+			v, err := readInt(r, wireType)
+			core.Core = int(int32(v))
+			return err
+		case 2:
+			return readDouble(r, wireType, &core.CPUPercent)
+		case 3:
+			return readString(r, wireType, &core.Timestamp)
+		}
+		return r.Skip(wireType)
+	})
+	return core, err
+}
+
+func decodeProcessInfo(buf []byte) (ProcessInfo, error) {
+	var p ProcessInfo
+	err := DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		switch field {
+		case 1:
+			v, err := readInt(r, wireType)
+			p.PID = int32(v)
+			return err
+		case 2:
+			return readString(r, wireType, &p.Name)
+		case 3:
+			return readDouble(r, wireType, &p.CPUPercent)
+		case 4:
+			if wireType != WireFixed32 {
+				return r.Skip(wireType)
+			}
+			v, err := r.Float()
+			p.MemPercent = v
+			return err
+		case 5:
+			return readString(r, wireType, &p.Status)
+		case 6:
+			return readString(r, wireType, &p.Username)
+		case 7:
+			v, err := readInt(r, wireType)
+			p.CreateTime = v
+			return err
+		case 8:
+			return readString(r, wireType, &p.CmdLine)
+		case 9:
+			v, err := readInt(r, wireType)
+			p.NumThreads = int32(v)
+			return err
+		}
+		return r.Skip(wireType)
+	})
+	return p, err
+}
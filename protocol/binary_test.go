@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testSnapshot() SystemData {
+	return SystemData{
+		SchemaVersion: 1,
+		UserAgent:     "cpu-agent/test",
+		CPUInfo:       CPUInfo{VendorID: "GenuineIntel", Family: "6", Model: "Core i7", MHz: "2400", CacheSize: "8192 KB"},
+		CoreData: []CPUClientCoreData{
+			{Core: 0, CPUPercent: 12.5, Timestamp: "2025-01-01T00:00:00Z"},
+			{Core: 1, CPUPercent: 0, Timestamp: "2025-01-01T00:00:00Z"},
+		},
+		Processes: []ProcessInfo{
+			{PID: 1, Name: "init", CPUPercent: 0.1, MemPercent: 0.5, Status: "S", Username: "root", CreateTime: 1700000000000, CmdLine: "/sbin/init", NumThreads: 1},
+			{PID: 4242, Name: "go", CPUPercent: 99.9, MemPercent: 12.25, Username: "dev", CreateTime: 1700000001000, NumThreads: 16},
+		},
+		Hostname:    "web-1",
+		OS:          "linux",
+		Platform:    "debian",
+		CollectedAt: "2025-01-01T00:00:00Z",
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	full := testSnapshot()
+	v2 := testSnapshot()
+	v2.SchemaVersion = 2
+	v2.Sequence = 7
+	delta := v2
+	delta.Sequence, delta.BaseSequence, delta.Processes = 8, 7, nil
+	delta.ProcessDelta = &ProcessDelta{
+		Upserted: []ProcessInfo{{PID: 99, Name: "new", CreateTime: 1700000002000}},
+		Removed:  []int32{1, 300, 70000},
+	}
+	empty := delta
+	empty.Sequence = 9
+	empty.ProcessDelta = &ProcessDelta{}
+
+	tests := []struct {
+		name    string
+		version int
+		data    SystemData
+	}{
+		{"version 1", 1, full},
+		{"version 2", 2, v2},
+		{"delta", 2, delta},
+		{"delta vide", 2, empty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := MarshalBinary(tt.data, tt.version)
+			if err != nil {
+				t.Fatalf("MarshalBinary: %v", err)
+			}
+			var got SystemData
+			if err := UnmarshalBinary(buf, &got); err != nil {
+				t.Fatalf("UnmarshalBinary: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.data) {
+				t.Errorf("aller-retour:\n got %+v\nwant %+v", got, tt.data)
+			}
+		})
+	}
+}
+
+// Les PID supprimés sont émis en forme compacte (un seul champ 2 de type
+// bytes), et la forme non compacte reste acceptée au décodage
+func TestBinaryRemovedEncoding(t *testing.T) {
+	data := testSnapshot()
+	data.SchemaVersion, data.Sequence, data.BaseSequence, data.Processes = 2, 2, 1, nil
+	data.ProcessDelta = &ProcessDelta{Removed: []int32{3, 1000, 5}}
+	buf, err := MarshalBinary(data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removedFields []int
+	err = DecodeMessage(buf, func(r *WireReader, field, wireType int) error {
+		if field != 12 {
+			return r.Skip(wireType)
+		}
+		return SubMessage(r, wireType, func(b []byte) error {
+			return DecodeMessage(b, func(r *WireReader, field, wireType int) error {
+				if field == 2 {
+					removedFields = append(removedFields, wireType)
+				}
+				return r.Skip(wireType)
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removedFields, []int{WireBytes}) {
+		t.Errorf("removed encodé en %v, attendu un champ compact", removedFields)
+	}
+
+	var e WireEncoder
+	e.VarintField(1, 2)
+	e.VarintField(11, 1)
+	e.MessageField(12, func(e *WireEncoder) {
+		for _, pid := range []uint64{3, 1000, 5} {
+			e.VarintField(2, pid)
+		}
+	})
+	var got SystemData
+	if err := UnmarshalBinary(e.Buf, &got); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got.ProcessDelta == nil || !reflect.DeepEqual(got.ProcessDelta.Removed, []int32{3, 1000, 5}) {
+		t.Errorf("removed non compact = %+v", got.ProcessDelta)
+	}
+}
+
+func TestBinaryRejects(t *testing.T) {
+	if _, err := MarshalBinary(testSnapshot(), 0); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("MarshalBinary version 0: %v", err)
+	}
+	delta := testSnapshot()
+	delta.BaseSequence, delta.ProcessDelta = 1, &ProcessDelta{}
+	if _, err := MarshalBinary(delta, 1); err == nil {
+		t.Error("delta accepté en version 1")
+	}
+
+	var e WireEncoder
+	e.VarintField(1, CurrentVersion+1)
+	var got SystemData
+	if err := UnmarshalBinary(e.Buf, &got); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("version future: %v", err)
+	}
+	buf, _ := MarshalBinary(testSnapshot(), 1)
+	if err := UnmarshalBinary(buf[:len(buf)-3], &got); err == nil {
+		t.Error("message tronqué accepté")
+	}
+}
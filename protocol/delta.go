@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Différence entre deux listes de processus : processus nouveaux ou
+// modifiés (remplacés en entier) et PID disparus
+type ProcessDelta struct {
+	Upserted []ProcessInfo `json:"upserted,omitempty"`
+	Removed  []int32       `json:"removed,omitempty"`
+}
+
+// En-têtes de réponse : séquence acquittée (base utilisable pour les
+// deltas suivants) et demande d'instantané complet
+const (
+	HeaderAck    = "X-Snapshot-Ack"
+	HeaderResync = "X-Snapshot-Resync"
+)
+
+// Vrai si l'instantané ne porte qu'un delta de processus
+func (d SystemData) IsDelta() bool {
+	return d.BaseSequence != 0
+}
+
+// Delta qui transforme base en current ; un processus est identifié par
+// son PID, un PID réutilisé (create_time différent) est un remplacement
+func DiffProcesses(base, current []ProcessInfo) ProcessDelta {
+	previous := make(map[int32]ProcessInfo, len(base))
+	for _, p := range base {
+		previous[p.PID] = p
+	}
+	var delta ProcessDelta
+	for _, p := range current {
+		old, ok := previous[p.PID]
+		if !ok || old != p {
+			delta.Upserted = append(delta.Upserted, p)
+		}
+		delete(previous, p.PID)
+	}
+	for pid := range previous {
+		delta.Removed = append(delta.Removed, pid)
+	}
+	sort.Slice(delta.Removed, func(i, j int) bool { return delta.Removed[i] < delta.Removed[j] })
+	return delta
+}
+
+// Reconstruit la liste complète à partir de la base : l'ordre de la base est
+// conservé (mises à jour sur place), les nouveaux PID sont ajoutés à la fin
+// dans l'ordre du delta
+func ApplyDelta(base []ProcessInfo, delta ProcessDelta) ([]ProcessInfo, error) {
+	index := make(map[int32]int, len(base))
+	for i, p := range base {
+		index[p.PID] = i
+	}
+	removed := make(map[int32]bool, len(delta.Removed))
+	for _, pid := range delta.Removed {
+		if _, ok := index[pid]; !ok {
+			return nil, fmt.Errorf("processus %d absent de la base", pid)
+		}
+		removed[pid] = true
+	}
+	result := append([]ProcessInfo(nil), base...)
+	for _, p := range delta.Upserted {
+		if i, ok := index[p.PID]; ok {
+			result[i] = p
+			delete(removed, p.PID)
+			continue
+		}
+		index[p.PID] = len(result)
+		result = append(result, p)
+	}
+	kept := result[:0]
+	for _, p := range result {
+		if !removed[p.PID] {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffApplyIdentity(t *testing.T) {
+	base := []ProcessInfo{
+		{PID: 1, Name: "init", CreateTime: 100},
+		{PID: 20, Name: "sshd", CPUPercent: 0.1, CreateTime: 200},
+		{PID: 30, Name: "cron", CreateTime: 300},
+		{PID: 40, Name: "nginx", CPUPercent: 5, CreateTime: 400},
+	}
+	tests := []struct {
+		name    string
+		current []ProcessInfo
+		delta   ProcessDelta
+	}{
+		{"inchangé", base, ProcessDelta{}},
+		{"valeur modifiée", []ProcessInfo{base[0], base[1], base[2], {PID: 40, Name: "nginx", CPUPercent: 50, CreateTime: 400}},
+			ProcessDelta{Upserted: []ProcessInfo{{PID: 40, Name: "nginx", CPUPercent: 50, CreateTime: 400}}}},
+		{"ajout et disparition", []ProcessInfo{base[0], base[2], {PID: 50, Name: "vim", CreateTime: 500}},
+			ProcessDelta{Upserted: []ProcessInfo{{PID: 50, Name: "vim", CreateTime: 500}}, Removed: []int32{20, 40}}},
+		// PID réutilisé : même numéro, autre processus, remplacé en entier
+		{"PID réutilisé", []ProcessInfo{base[0], base[1], {PID: 30, Name: "backup", CreateTime: 900}, base[3]},
+			ProcessDelta{Upserted: []ProcessInfo{{PID: 30, Name: "backup", CreateTime: 900}}}},
+		{"tout disparu", nil, ProcessDelta{Removed: []int32{1, 20, 30, 40}}},
+		// l'ordre de la base est conservé, un nouveau PID est ajouté à la fin
+		{"nouveau PID plus petit", []ProcessInfo{base[0], base[1], base[2], base[3], {PID: 5, Name: "kworker", CreateTime: 600}},
+			ProcessDelta{Upserted: []ProcessInfo{{PID: 5, Name: "kworker", CreateTime: 600}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := DiffProcesses(base, tt.current)
+			if !reflect.DeepEqual(delta, tt.delta) {
+				t.Errorf("DiffProcesses = %+v, attendu %+v", delta, tt.delta)
+			}
+			got, err := ApplyDelta(base, delta)
+			if err != nil {
+				t.Fatalf("ApplyDelta: %v", err)
+			}
+			want := tt.current
+			if want == nil {
+				want = []ProcessInfo{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ApplyDelta(DiffProcesses) = %+v, attendu %+v", got, want)
+			}
+		})
+	}
+}
+
+// Un delta calculé sur une autre base que celle du serveur est refusé
+func TestApplyDeltaUnknownPID(t *testing.T) {
+	base := []ProcessInfo{{PID: 1, Name: "init"}}
+	if _, err := ApplyDelta(base, ProcessDelta{Removed: []int32{2}}); err == nil {
+		t.Error("suppression d'un PID absent de la base acceptée")
+	}
+}
+
+// Une base qui n'est pas triée par PID (tri par CPU de l'agent) garde son ordre
+func TestApplyDeltaKeepsBaseOrder(t *testing.T) {
+	base := []ProcessInfo{
+		{PID: 40, Name: "nginx", CPUPercent: 50},
+		{PID: 1, Name: "init", CPUPercent: 2},
+		{PID: 20, Name: "sshd", CPUPercent: 1},
+	}
+	delta := ProcessDelta{
+		Upserted: []ProcessInfo{{PID: 20, Name: "sshd", CPUPercent: 3}, {PID: 7, Name: "vim"}, {PID: 3, Name: "bash"}},
+		Removed:  []int32{1},
+	}
+	got, err := ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("ApplyDelta: %v", err)
+	}
+	var pids []int32
+	for _, p := range got {
+		pids = append(pids, p.PID)
+	}
+	if want := []int32{40, 20, 7, 3}; !reflect.DeepEqual(pids, want) {
+		t.Errorf("PID = %v, attendu %v", pids, want)
+	}
+	if got[1].CPUPercent != 3 {
+		t.Errorf("sshd non mis à jour: %+v", got[1])
+	}
+}
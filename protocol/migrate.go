@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version 0 : user_agent dans cpu_info et dans chaque cœur
+type cpuInfoV0 struct {
+	CPUInfo
+	UserAgent string `json:"user_agent"`
+}
+
+type coreDataV0 struct {
+	CPUClientCoreData
+	UserAgent string `json:"user_agent"`
+}
+
+type systemDataV0 struct {
+	CPUInfo     cpuInfoV0     `json:"cpu_info"`
+	CoreData    []coreDataV0  `json:"core_data"`
+	Processes   []ProcessInfo `json:"processes"`
+	Hostname    string        `json:"hostname"`
+	OS          string        `json:"os"`
+	Platform    string        `json:"platform"`
+	CollectedAt string        `json:"collected_at"`
+}
+
+func upgradeV0(old systemDataV0) SystemData {
+	data := SystemData{
+		SchemaVersion: 1,
+		UserAgent:     old.CPUInfo.UserAgent,
+		CPUInfo:       old.CPUInfo.CPUInfo,
+		Processes:     old.Processes,
+		Hostname:      old.Hostname,
+		OS:            old.OS,
+		Platform:      old.Platform,
+		CollectedAt:   old.CollectedAt,
+	}
+	if old.CoreData != nil {
+		data.CoreData = make([]CPUClientCoreData, len(old.CoreData))
+	}
+	for i, core := range old.CoreData {
+		data.CoreData[i] = core.CPUClientCoreData
+		if data.UserAgent == "" {
+			data.UserAgent = core.UserAgent
+		}
+	}
+	return data
+}
+
+func downgradeV0(data SystemData) systemDataV0 {
+	old := systemDataV0{
+		CPUInfo:     cpuInfoV0{CPUInfo: data.CPUInfo, UserAgent: data.UserAgent},
+		Processes:   data.Processes,
+		Hostname:    data.Hostname,
+		OS:          data.OS,
+		Platform:    data.Platform,
+		CollectedAt: data.CollectedAt,
+	}
+	if data.CoreData != nil {
+		old.CoreData = make([]coreDataV0, len(data.CoreData))
+	}
+	for i, core := range data.CoreData {
+		old.CoreData[i] = coreDataV0{CPUClientCoreData: core, UserAgent: data.UserAgent}
+	}
+	return old
+}
+
+// Décode un instantané de n'importe quelle version supportée et le met à
+// niveau vers CurrentVersion
+func Unmarshal(raw []byte, data *SystemData) error {
+	var decoded SystemData
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return err
+	}
+	switch {
+	case decoded.SchemaVersion == 0:
+		var old systemDataV0
+		if err := json.Unmarshal(raw, &old); err != nil {
+			return err
+		}
+		decoded = upgradeV0(old)
+	case decoded.SchemaVersion < MinVersion || decoded.SchemaVersion > CurrentVersion:
+		return fmt.Errorf("%w: %d (acceptées: %s)", ErrUnsupportedVersion, decoded.SchemaVersion, FormatVersions(SupportedVersions()))
+	}
+	dropLaterFields(&decoded)
+	*data = decoded
+	return nil
+}
+
+// Les champs de la version 2 (séquence, delta) n'ont pas de sens dans un
+// envoi plus ancien : ils sont ignorés plutôt qu'interprétés
+func dropLaterFields(data *SystemData) {
+	if data.SchemaVersion < 2 {
+		data.Sequence, data.BaseSequence, data.ProcessDelta = 0, 0, nil
+	}
+}
+
+// Encode un instantané dans la version demandée (négociée avec le serveur)
+func Marshal(data SystemData, version int) ([]byte, error) {
+	switch version {
+	case 0:
+		if data.IsDelta() {
+			return nil, fmt.Errorf("delta impossible en version 0")
+		}
+		return json.Marshal(downgradeV0(data))
+	case 1:
+		if data.IsDelta() {
+			return nil, fmt.Errorf("delta impossible en version 1")
+		}
+		data.Sequence = 0
+		data.SchemaVersion = 1
+		return json.Marshal(data)
+	case CurrentVersion:
+		data.SchemaVersion = CurrentVersion
+		return json.Marshal(data)
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+}
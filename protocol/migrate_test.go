@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Instantané tel qu'envoyé par un agent de version 0
+const snapshotV0 = `{
+	"cpu_info": {"vendor": "GenuineIntel", "family": "6", "model": "Core i7", "mhz": "2400", "cache_size": "8192 KB", "user_agent": "cpu-agent/0.9"},
+	"core_data": [
+		{"core": 0, "cpu_percent": 12.5, "timestamp": "2025-01-01T00:00:00Z", "user_agent": "cpu-agent/0.9"},
+		{"core": 1, "cpu_percent": 3, "timestamp": "2025-01-01T00:00:00Z", "user_agent": "cpu-agent/0.9"}
+	],
+	"processes": [{"pid": 1, "name": "init", "cpu_percent": 0.1, "memory_percent": 0.5, "status": "S", "username": "root", "create_time": 1700000000000, "cmdline": "/sbin/init", "num_threads": 1}],
+	"hostname": "web-1",
+	"os": "linux",
+	"platform": "debian",
+	"collected_at": "2025-01-01T00:00:00Z"
+}`
+
+func TestUnmarshalV0(t *testing.T) {
+	var got SystemData
+	if err := Unmarshal([]byte(snapshotV0), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := testSnapshot()
+	want.UserAgent = "cpu-agent/0.9"
+	want.CoreData[1].CPUPercent = 3
+	want.Processes = want.Processes[:1]
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mise à niveau:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+// Le user_agent d'un cœur est repris quand cpu_info n'en porte pas
+func TestUpgradeV0CoreUserAgent(t *testing.T) {
+	old := systemDataV0{CoreData: []coreDataV0{{CPUClientCoreData: CPUClientCoreData{Core: 0}, UserAgent: "cpu-agent/0.8"}}}
+	if got := upgradeV0(old); got.UserAgent != "cpu-agent/0.8" {
+		t.Errorf("UserAgent = %q", got.UserAgent)
+	}
+}
+
+// Un instantané rétrogradé pour un ancien serveur se relit à l'identique
+func TestMarshalDowngrade(t *testing.T) {
+	data := testSnapshot()
+	data.SchemaVersion, data.Sequence = CurrentVersion, 5
+
+	raw, err := Marshal(data, 0)
+	if err != nil {
+		t.Fatalf("Marshal v0: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"schema_version", "user_agent", "sequence"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("champ %s présent en version 0", field)
+		}
+	}
+	var got SystemData
+	if err := Unmarshal(raw, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := data
+	want.SchemaVersion, want.Sequence = 1, 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aller-retour v0:\n got %+v\nwant %+v", got, want)
+	}
+
+	if raw, err = Marshal(data, 1); err != nil {
+		t.Fatalf("Marshal v1: %v", err)
+	}
+	if err := Unmarshal(raw, &got); err != nil || got.SchemaVersion != 1 || got.Sequence != 0 {
+		t.Errorf("version 1 = %+v, %v", got, err)
+	}
+
+	delta := data
+	delta.BaseSequence, delta.ProcessDelta = 4, &ProcessDelta{}
+	for _, version := range []int{0, 1} {
+		if _, err := Marshal(delta, version); err == nil {
+			t.Errorf("delta accepté en version %d", version)
+		}
+	}
+}
+
+func TestUnmarshalUnsupportedVersion(t *testing.T) {
+	var got SystemData
+	err := Unmarshal([]byte(`{"schema_version": 99, "hostname": "web-1"}`), &got)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unmarshal version 99: %v", err)
+	}
+}
+
+// Séquence et delta ne sont interprétés qu'à partir de la version 2
+func TestUnmarshalDeltaFieldsByVersion(t *testing.T) {
+	tests := []struct {
+		version   int
+		wantDelta bool
+	}{
+		{0, false},
+		{1, false},
+		{2, true},
+	}
+	for _, tt := range tests {
+		raw := fmt.Sprintf(`{"schema_version": %d, "hostname": "web-1", "sequence": 7, "base_sequence": 6, "process_delta": {"removed": [42]}}`, tt.version)
+		var got SystemData
+		if err := Unmarshal([]byte(raw), &got); err != nil {
+			t.Fatalf("version %d: %v", tt.version, err)
+		}
+		if got.IsDelta() != tt.wantDelta || (got.Sequence == 7) != tt.wantDelta {
+			t.Errorf("version %d: delta = %v, sequence = %d", tt.version, got.IsDelta(), got.Sequence)
+		}
+	}
+}
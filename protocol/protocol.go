@@ -0,0 +1,139 @@
+// Package protocol définit le format des instantanés échangés entre l'agent
+// et le serveur, ses versions et les migrations entre versions.
+//
+// Versions du schéma :
+//
+//	0  format historique, sans schema_version ; user_agent répété dans
+//	   cpu_info et dans chaque cœur
+//	1  schema_version explicite ; user_agent au niveau de l'instantané ;
+//	   JSON ou protobuf
+//	2  numéro de séquence ; processus envoyés en delta par rapport à un
+//	   instantané acquitté par le serveur (voir delta.go)
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version produite par ce paquet
+const CurrentVersion = 2
+
+// Plus ancienne version encore acceptée
+const MinVersion = 0
+
+// En-tête par lequel le serveur annonce les versions qu'il accepte ; un
+// serveur qui ne l'envoie pas ne connaît que la version 0
+const HeaderVersions = "X-Schema-Versions"
+
+// Formats du corps accepté sur /cpu (protobuf : voir binary.go)
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// En-têtes de réponse annonçant les formats (Accept-Post) et les
+// compressions (Accept-Encoding) acceptés ; absents : JSON non compressé
+const (
+	HeaderAcceptPost     = "Accept-Post"
+	HeaderAcceptEncoding = "Accept-Encoding"
+)
+
+var ErrUnsupportedVersion = errors.New("schema_version non supportée")
+
+// Versions acceptées, de la plus ancienne à la plus récente
+func SupportedVersions() []int {
+	versions := make([]int, 0, CurrentVersion-MinVersion+1)
+	for v := MinVersion; v <= CurrentVersion; v++ {
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// CPU
+type CPUInfo struct {
+	VendorID  string `json:"vendor"`
+	Family    string `json:"family"`
+	Model     string `json:"model"`
+	MHz       string `json:"mhz"`
+	CacheSize string `json:"cache_size"`
+}
+
+// Données par cœur
+type CPUClientCoreData struct {
+	Core       int     `json:"core"`
+	CPUPercent float64 `json:"cpu_percent"`
+	Timestamp  string  `json:"timestamp"`
+}
+
+// Infos processus
+type ProcessInfo struct {
+	PID        int32   `json:"pid"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemPercent float32 `json:"memory_percent"`
+	Status     string  `json:"status"`
+	Username   string  `json:"username"`
+	CreateTime int64   `json:"create_time"`
+	CmdLine    string  `json:"cmdline"`
+	NumThreads int32   `json:"num_threads"`
+}
+
+// Instantané complet d'un hôte (version courante)
+type SystemData struct {
+	SchemaVersion int                 `json:"schema_version"`
+	UserAgent     string              `json:"user_agent,omitempty"`
+	CPUInfo       CPUInfo             `json:"cpu_info"`
+	CoreData      []CPUClientCoreData `json:"core_data"`
+	Processes     []ProcessInfo       `json:"processes"`
+	Hostname      string              `json:"hostname"`
+	OS            string              `json:"os"`
+	Platform      string              `json:"platform"`
+	CollectedAt   string              `json:"collected_at"`
+
+	// Version 2 : numéro de l'instantané et, pour un delta, séquence de
+	// la base ; Processes est alors vide et remplacé par ProcessDelta
+	Sequence     uint64        `json:"sequence,omitempty"`
+	BaseSequence uint64        `json:"base_sequence,omitempty"`
+	ProcessDelta *ProcessDelta `json:"process_delta,omitempty"`
+}
+
+// Valeur de l'en-tête HeaderVersions : "0,1"
+func FormatVersions(versions []int) string {
+	parts := make([]string, len(versions))
+	for i, v := range versions {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Lit l'en-tête HeaderVersions ; vide : serveur antérieur aux versions (0)
+func ParseVersions(header string) ([]int, error) {
+	if strings.TrimSpace(header) == "" {
+		return []int{0}, nil
+	}
+	var versions []int
+	for _, part := range strings.Split(header, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("version invalide %q", part)
+		}
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// Plus haute version commune avec celles annoncées par le serveur
+func Negotiate(remote []int) (int, bool) {
+	best, ok := 0, false
+	for _, v := range remote {
+		if v >= MinVersion && v <= CurrentVersion && (!ok || v > best) {
+			best, ok = v, true
+		}
+	}
+	return best, ok
+}
@@ -0,0 +1,173 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Types de fil protobuf
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+var ErrTruncated = errors.New("protobuf tronqué")
+
+// Lecteur minimal du format de fil protobuf
+type WireReader struct {
+	buf []byte
+	pos int
+}
+
+func NewWireReader(buf []byte) *WireReader { return &WireReader{buf: buf} }
+
+func (r *WireReader) Done() bool { return r.pos >= len(r.buf) }
+
+// Prochaine clé : numéro de champ et type de fil
+func (r *WireReader) Next() (int, int, error) {
+	key, err := r.Varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field := int(key >> 3)
+	if field == 0 {
+		return 0, 0, fmt.Errorf("numéro de champ nul à l'offset %d", r.pos)
+	}
+	return field, int(key & 7), nil
+}
+
+func (r *WireReader) Varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, ErrTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *WireReader) Fixed64() (uint64, error) {
+	if len(r.buf)-r.pos < 8 {
+		return 0, ErrTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+func (r *WireReader) Fixed32() (uint32, error) {
+	if len(r.buf)-r.pos < 4 {
+		return 0, ErrTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *WireReader) Bytes() ([]byte, error) {
+	size, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.buf)-r.pos) {
+		return nil, ErrTruncated
+	}
+	b := r.buf[r.pos : r.pos+int(size)]
+	r.pos += int(size)
+	return b, nil
+}
+
+func (r *WireReader) Double() (float64, error) {
+	v, err := r.Fixed64()
+	return math.Float64frombits(v), err
+}
+
+func (r *WireReader) Float() (float32, error) {
+	v, err := r.Fixed32()
+	return math.Float32frombits(v), err
+}
+
+// Ignore la valeur d'un champ inconnu
+func (r *WireReader) Skip(wireType int) error {
+	var err error
+	switch wireType {
+	case WireVarint:
+		_, err = r.Varint()
+	case WireFixed64:
+		_, err = r.Fixed64()
+	case WireBytes:
+		_, err = r.Bytes()
+	case WireFixed32:
+		_, err = r.Fixed32()
+	default:
+		err = fmt.Errorf("type de fil %d non supporté", wireType)
+	}
+	return err
+}
+
+// Parcourt les champs d'un message et délègue chacun à fn
+func DecodeMessage(buf []byte, fn func(r *WireReader, field, wireType int) error) error {
+	r := NewWireReader(buf)
+	for !r.Done() {
+		field, wireType, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if err := fn(r, field, wireType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lit un sous-message (type de fil bytes) du champ courant
+func SubMessage(r *WireReader, wireType int, decode func([]byte) error) error {
+	if wireType != WireBytes {
+		return r.Skip(wireType)
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	return decode(b)
+}
+
+// Encodeur minimal du format de fil protobuf
+type WireEncoder struct {
+	Buf []byte
+}
+
+func (e *WireEncoder) Key(field, wireType int) {
+	e.Buf = binary.AppendUvarint(e.Buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *WireEncoder) VarintField(field int, v uint64) {
+	e.Key(field, WireVarint)
+	e.Buf = binary.AppendUvarint(e.Buf, v)
+}
+
+func (e *WireEncoder) BytesField(field int, b []byte) {
+	e.Key(field, WireBytes)
+	e.Buf = binary.AppendUvarint(e.Buf, uint64(len(b)))
+	e.Buf = append(e.Buf, b...)
+}
+
+func (e *WireEncoder) DoubleField(field int, v float64) {
+	e.Key(field, WireFixed64)
+	e.Buf = binary.LittleEndian.AppendUint64(e.Buf, math.Float64bits(v))
+}
+
+func (e *WireEncoder) FloatField(field int, v float32) {
+	e.Key(field, WireFixed32)
+	e.Buf = binary.LittleEndian.AppendUint32(e.Buf, math.Float32bits(v))
+}
+
+// Sous-message encodé par fn
+func (e *WireEncoder) MessageField(field int, fn func(*WireEncoder)) {
+	var sub WireEncoder
+	fn(&sub)
+	e.BytesField(field, sub.Buf)
+}
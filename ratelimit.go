@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Débits autorisés sur les points d'ingestion (envois par seconde) ; un
+// débit nul désactive la limite correspondante
+type RateLimitConfig struct {
+	HostRate    float64
+	HostBurst   float64
+	GlobalRate  float64
+	GlobalBurst float64
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{HostRate: 0.2, HostBurst: 5, GlobalRate: 100, GlobalBurst: 200}
+}
+
+// Seau à jetons : se remplit à rate jetons/s, jusqu'à burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Prend un jeton ; sinon retourne l'attente avant le prochain
+func (b *tokenBucket) take(rate, burst float64, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limiteur global et par hôte (ou par adresse source)
+type RateLimiter struct {
+	mu        sync.Mutex
+	cfg       RateLimitConfig
+	global    tokenBucket
+	hosts     map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{cfg: cfg, hosts: make(map[string]*tokenBucket)}
+}
+
+// Envoi refusé pour cause de débit : 429 (limite) ou 503 (file pleine)
+type throttleError struct {
+	status     int
+	retryAfter time.Duration
+	reason     string
+}
+
+func (e *throttleError) Error() string {
+	return fmt.Sprintf("%s, réessayer dans %s", e.reason, e.retryAfter.Round(time.Second))
+}
+
+// Limite globale, vérifiée avant de lire le corps
+func (l *RateLimiter) AllowGlobal(now time.Time) error {
+	if l == nil || l.cfg.GlobalRate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	wait := l.global.take(l.cfg.GlobalRate, l.cfg.GlobalBurst, now)
+	l.mu.Unlock()
+	if wait > 0 {
+		counters.rateLimited.Add(1)
+		return &throttleError{status: http.StatusTooManyRequests, retryAfter: wait, reason: "limite globale d'ingestion atteinte"}
+	}
+	return nil
+}
+
+// Limite par hôte
+func (l *RateLimiter) AllowHost(hostname string, now time.Time) error {
+	if l == nil || l.cfg.HostRate <= 0 {
+		return nil
+	}
+	if wait := l.takeKey(hostname, now); wait > 0 {
+		counters.rateLimited.Add(1)
+		return &throttleError{status: http.StatusTooManyRequests, retryAfter: wait, reason: fmt.Sprintf("trop d'envois pour %s", hostname)}
+	}
+	return nil
+}
+
+// Limite par adresse source, pour les tentatives de connexion (débit et
+// rafale par clé de la configuration)
+func (l *RateLimiter) AllowSource(ip string, now time.Time) error {
+	if l == nil || l.cfg.HostRate <= 0 {
+		return nil
+	}
+	if wait := l.takeKey(ip, now); wait > 0 {
+		return &throttleError{status: http.StatusTooManyRequests, retryAfter: wait, reason: "trop de tentatives de connexion"}
+	}
+	return nil
+}
+
+// Prend un jeton dans le seau d'une clé (hôte ou adresse)
+func (l *RateLimiter) takeKey(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now)
+	b, ok := l.hosts[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.hosts[key] = b
+	}
+	return b.take(l.cfg.HostRate, l.cfg.HostBurst, now)
+}
+
+// Oublie les seaux redevenus pleins (hôte inactif)
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	full := time.Duration(l.cfg.HostBurst / l.cfg.HostRate * float64(time.Second))
+	for hostname, b := range l.hosts {
+		if now.Sub(b.last) > full {
+			delete(l.hosts, hostname)
+		}
+	}
+}
+
+// Ajoute Retry-After (en secondes, arrondi au supérieur) à un refus pour débit
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var throttled *throttleError
+	if errors.As(err, &throttled) {
+		secs := int(math.Ceil(throttled.retryAfter.Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+}
+
+// Statut HTTP d'un refus, ou fallback s'il ne s'agit pas d'un refus pour débit
+func throttleStatus(err error, fallback int) int {
+	var throttled *throttleError
+	if errors.As(err, &throttled) {
+		return throttled.status
+	}
+	return fallback
+}
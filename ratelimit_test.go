@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowHost(t *testing.T) {
+	start := time.Date(2025, 9, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		cfg      RateLimitConfig
+		hostname string
+		at       time.Duration // depuis start
+		wantWait time.Duration // 0 : accepté
+	}{
+		// 1 envoi toutes les 10 s, rafale de 2
+		{"rafale 1", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-1", 0, 0},
+		{"rafale 2", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-1", 0, 0},
+		{"rafale épuisée", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-1", time.Second, 9 * time.Second},
+		{"autre hôte", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-2", time.Second, 0},
+		{"jeton regagné", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-1", 11 * time.Second, 0},
+		{"de nouveau épuisé", RateLimitConfig{HostRate: 0.1, HostBurst: 2}, "web-1", 12 * time.Second, 8 * time.Second},
+	}
+	l := NewRateLimiter(tests[0].cfg)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := l.AllowHost(tt.hostname, start.Add(tt.at))
+			var throttled *throttleError
+			switch {
+			case tt.wantWait == 0 && err != nil:
+				t.Fatalf("refusé: %v", err)
+			case tt.wantWait > 0 && !errors.As(err, &throttled):
+				t.Fatalf("erreur %v, attendu un refus", err)
+			case tt.wantWait > 0:
+				if throttled.status != http.StatusTooManyRequests {
+					t.Errorf("statut %d", throttled.status)
+				}
+				if d := throttled.retryAfter - tt.wantWait; d < -time.Millisecond || d > time.Millisecond {
+					t.Errorf("attente %s, attendu %s", throttled.retryAfter, tt.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllowGlobal(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		limiter  *RateLimiter
+		requests int
+		accepted int
+	}{
+		{"limiteur absent", nil, 5, 5},
+		{"limite désactivée", NewRateLimiter(RateLimitConfig{}), 5, 5},
+		{"rafale de 3", NewRateLimiter(RateLimitConfig{GlobalRate: 1, GlobalBurst: 3}), 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted := 0
+			for i := 0; i < tt.requests; i++ {
+				if tt.limiter.AllowGlobal(now) == nil {
+					accepted++
+				}
+			}
+			if accepted != tt.accepted {
+				t.Errorf("%d envois acceptés, attendu %d", accepted, tt.accepted)
+			}
+			if err := tt.limiter.AllowHost("web-1", now); err != nil {
+				t.Errorf("limite par hôte désactivée: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   string
+		status int
+	}{
+		{"arrondi au supérieur", &throttleError{status: http.StatusTooManyRequests, retryAfter: 2100 * time.Millisecond}, "3", http.StatusTooManyRequests},
+		{"au moins une seconde", &throttleError{status: http.StatusServiceUnavailable, retryAfter: time.Millisecond}, "1", http.StatusServiceUnavailable},
+		{"autre erreur", errors.New("disque plein"), "", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setRetryAfter(rec, tt.err)
+			if got := rec.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("Retry-After = %q, attendu %q", got, tt.want)
+			}
+			if got := throttleStatus(tt.err, http.StatusInternalServerError); got != tt.status {
+				t.Errorf("throttleStatus = %d, attendu %d", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestIngestQueueShedsWhenFull(t *testing.T) {
+	q := NewIngestQueue(0, 1)
+	q.jobs <- ingestJob{}
+	err := q.Do(func() error { t.Error("exécuté malgré la file pleine"); return nil })
+	if got := throttleStatus(err, 0); got != http.StatusServiceUnavailable {
+		t.Fatalf("Do = %v, attendu un refus 503", err)
+	}
+	if q.Len() != 1 {
+		t.Errorf("Len = %d", q.Len())
+	}
+
+	var nilQueue *IngestQueue
+	ran := false
+	if err := nilQueue.Do(func() error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("file absente: exécution directe attendue (%v)", err)
+	}
+}
+
+// Le refus porte Retry-After sur les points d'ingestion
+func TestIngestRetryAfter(t *testing.T) {
+	saved := limiter
+	defer func() { limiter = saved }()
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		target      string
+		contentType string
+		body        string
+	}{
+		{"agent", handleCPU, "/cpu", "application/json", `{"hostname":"web-1"}`},
+		{"line protocol", handleWrite, "/write", "text/plain", "cpu,host=web-1,cpu=cpu0 usage_idle=50"},
+		{"OTLP", handleOTLPMetrics, "/v1/metrics", "application/json", `{"resourceMetrics":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter = NewRateLimiter(RateLimitConfig{GlobalRate: 0.5, GlobalBurst: 1})
+			limiter.AllowGlobal(time.Now())
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("statut %d, attendu 429: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("Retry-After = %q, attendu 2", got)
+			}
+		})
+	}
+}
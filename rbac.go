@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+// Groupe d'hôtes : un hôte en fait partie si son nom correspond à l'un des
+// motifs et si tous les labels correspondent
+type HostGroup struct {
+	Name      string            `json:"name"`
+	Hostnames []string          `json:"hostnames,omitempty"` // regex ancrées ; vide : tous les noms
+	Labels    map[string]string `json:"labels,omitempty"`    // regex par label : os, platform
+
+	hostnames []*regexp.Regexp
+	labels    map[string]*regexp.Regexp
+}
+
+// Groupes d'hôtes par nom
+var hostGroups = map[string]*HostGroup{}
+
+var errKeyNotFound = errors.New("clé inconnue")
+
+// Lit et valide un fichier de groupes JSON
+func LoadHostGroups(path string) (map[string]*HostGroup, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Groups []*HostGroup `json:"groups"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	groups := make(map[string]*HostGroup, len(file.Groups))
+	for i, g := range file.Groups {
+		if err := g.compile(); err != nil {
+			return nil, fmt.Errorf("%s: groupe %d (%s): %v", path, i, g.Name, err)
+		}
+		if _, dup := groups[g.Name]; dup {
+			return nil, fmt.Errorf("%s: groupe %s défini deux fois", path, g.Name)
+		}
+		groups[g.Name] = g
+	}
+	return groups, nil
+}
+
+func (g *HostGroup) compile() error {
+	if g.Name == "" || g.Name == user.AllGroups {
+		return fmt.Errorf("name invalide")
+	}
+	for _, pattern := range g.Hostnames {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("hostnames: %v", err)
+		}
+		g.hostnames = append(g.hostnames, re)
+	}
+	g.labels = make(map[string]*regexp.Regexp, len(g.Labels))
+	for label, pattern := range g.Labels {
+		switch label {
+		case "os", "platform":
+		default:
+			return fmt.Errorf("label inconnu: %q", label)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("labels %s: %v", label, err)
+		}
+		g.labels[label] = re
+	}
+	return nil
+}
+
+func (g *HostGroup) Contains(data SystemData) bool {
+	matched := len(g.hostnames) == 0
+	for _, re := range g.hostnames {
+		if re.MatchString(data.Hostname) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	labels := map[string]string{"os": data.OS, "platform": data.Platform}
+	for label, re := range g.labels {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Appelant authentifié : compte (session ou clé d'API) ou jeton d'administration
+type Principal struct {
+	Login  string
+	Role   user.Role
+	Groups []string
+	Via    string // session, api_key, admin_token
+}
+
+func principalFor(u user.User, via string) Principal {
+	return Principal{Login: u.Login, Role: u.Role, Groups: u.Groups, Via: via}
+}
+
+// Les administrateurs et le groupe "*" voient tous les hôtes
+func (p Principal) seesAll() bool {
+	if p.Role == user.RoleAdmin {
+		return true
+	}
+	for _, g := range p.Groups {
+		if g == user.AllGroups {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Principal) CanSee(data SystemData) bool {
+	if p.seesAll() {
+		return true
+	}
+	for _, name := range p.Groups {
+		if g, ok := hostGroups[name]; ok && g.Contains(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visibilité d'un hôte par son nom : labels repris du registre, sinon du
+// dernier instantané stocké ; un hôte inconnu des deux n'est pas visible
+// (sans labels, un groupe filtré par os ou platform ne peut pas trancher)
+func (p Principal) CanSeeHost(hostname string) bool {
+	if p.seesAll() {
+		return true
+	}
+	data, _, ok := registry.Get(hostname)
+	if !ok && store != nil {
+		var err error
+		data, ok, err = store.Latest(hostname)
+		ok = ok && err == nil
+	}
+	return ok && p.CanSee(data)
+}
+
+type principalContextKey struct{}
+
+func principalFrom(r *http.Request) Principal {
+	p, _ := r.Context().Value(principalContextKey{}).(Principal)
+	return p
+}
+
+// Identifie l'appelant : jeton d'administration, clé d'API ou cookie de session
+func authenticate(r *http.Request) (Principal, int, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if _, _, isKey := user.ParseAPIKey(token); isKey {
+			u, ok := users.FindAPIKey(token)
+			if !ok {
+				return Principal{}, http.StatusUnauthorized, fmt.Errorf("clé d'API invalide")
+			}
+			return principalFor(u, "api_key"), 0, nil
+		}
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			return Principal{}, http.StatusUnauthorized, fmt.Errorf("jeton invalide")
+		}
+		return Principal{Login: "admin-token", Role: user.RoleAdmin, Groups: []string{user.AllGroups}, Via: "admin_token"}, 0, nil
+	}
+
+	s, u, ok := sessionFrom(r)
+	if !ok {
+		return Principal{}, http.StatusUnauthorized, fmt.Errorf("connexion requise")
+	}
+	// les jetons portés par un en-tête ne sont pas envoyés par un autre site ;
+	// le cookie si, d'où le jeton CSRF pour les requêtes qui modifient
+	if !isSafeMethod(r.Method) && !s.CheckCSRF(r.Header.Get(headerCSRF)) {
+		return Principal{}, http.StatusForbidden, fmt.Errorf("jeton CSRF invalide")
+	}
+	return principalFor(u, "session"), 0, nil
+}
+
+// Point unique de contrôle d'accès des routes /api : read est le rôle requis
+// pour les lectures, write pour les requêtes qui modifient
+func authorize(read, write user.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, status, err := authenticate(r)
+		if err != nil {
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		required := read
+		if !isSafeMethod(r.Method) {
+			required = write
+		}
+		if !p.Role.Allows(required) {
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("rôle %s requis", required))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	}
+}
+
+// État courant des hôtes visibles par l'appelant
+func visibleClients(r *http.Request) (map[string]SystemData, map[string]HostMeta) {
+	clients, metas := clientsSnapshot()
+	p := principalFrom(r)
+	if p.seesAll() {
+		return clients, metas
+	}
+	for hostname, data := range clients {
+		if !p.CanSee(data) {
+			delete(clients, hostname)
+			delete(metas, hostname)
+		}
+	}
+	return clients, metas
+}
+
+// Clés d'API personnelles : GET liste, POST crée (jeton affiché une fois), DELETE ?id= révoque
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r)
+	if p.Via == "admin_token" {
+		writeJSONError(w, http.StatusBadRequest, "réservé aux comptes utilisateurs")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		u, _ := users.Get(p.Login)
+		keys := []user.APIKey{}
+		for _, k := range u.APIKeys {
+			k.Hash = ""
+			keys = append(keys, k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Impossible de décoder le JSON")
+			return
+		}
+		key, token := user.NewAPIKey(req.Name)
+		_, err := users.Update(p.Login, func(u *user.User) error {
+			u.APIKeys = append(u.APIKeys, key)
+			return nil
+		})
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		fmt.Printf("🔑 Clé d'API %s créée pour %s\n", key.ID, p.Login)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         key.ID,
+			"name":       key.Name,
+			"token":      token,
+			"created_at": key.CreatedAt,
+		})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		_, err := users.Update(p.Login, func(u *user.User) error {
+			for i, k := range u.APIKeys {
+				if k.ID == id {
+					u.APIKeys = append(u.APIKeys[:i:i], u.APIKeys[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: clé %s", errKeyNotFound, id)
+		})
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "id": id})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+	}
+}
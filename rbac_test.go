@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+func TestCanSeeHost(t *testing.T) {
+	groups := []*HostGroup{
+		{Name: "web", Hostnames: []string{"web-.*"}},
+		{Name: "linux", Labels: map[string]string{"os": "linux"}},
+	}
+	savedGroups, savedRegistry, savedStore := hostGroups, registry, store
+	defer func() { hostGroups, registry, store = savedGroups, savedRegistry, savedStore }()
+	hostGroups = map[string]*HostGroup{}
+	for _, g := range groups {
+		if err := g.compile(); err != nil {
+			t.Fatal(err)
+		}
+		hostGroups[g.Name] = g
+	}
+
+	now := time.Now()
+	registry = NewRegistry()
+	for _, data := range []SystemData{
+		{Hostname: "web-1", OS: "linux"},
+		{Hostname: "db-1", OS: "windows"},
+	} {
+		data.CollectedAt = now.UTC().Format(time.RFC3339)
+		registry.Update(data, "192.0.2.1", now)
+	}
+	// connu du seul stockage (registre pas encore restauré, hôte supprimé...)
+	store = NewMemoryStore(defaultRetention())
+	stored := testSnapshot("db-2", now, 10)
+	stored.OS = "linux"
+	if err := store.SaveSnapshot(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	viewer := func(groups ...string) Principal {
+		return Principal{Login: "bob", Role: user.RoleViewer, Groups: groups}
+	}
+	tests := []struct {
+		name     string
+		p        Principal
+		hostname string
+		want     bool
+	}{
+		{"admin, hôte inconnu", Principal{Role: user.RoleAdmin}, "ghost", true},
+		{"groupe *", viewer(user.AllGroups), "db-1", true},
+		{"nom dans le groupe", viewer("web"), "web-1", true},
+		{"nom hors du groupe", viewer("web"), "db-1", false},
+		{"nom inconnu du registre et du stockage", viewer("web"), "web-9", false},
+		{"label depuis le registre", viewer("linux"), "web-1", true},
+		{"label différent", viewer("linux"), "db-1", false},
+		{"label depuis le stockage", viewer("linux"), "db-2", true},
+		{"hôte inconnu, groupe par label", viewer("linux"), "ghost", false},
+		{"groupe inexistant", viewer("absent"), "web-1", false},
+		{"sans groupe", viewer(), "web-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.CanSeeHost(tt.hostname); got != tt.want {
+				t.Errorf("CanSeeHost(%q) = %v, attendu %v", tt.hostname, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Métadonnées d'un hôte connu du serveur
+type HostMeta struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	PushCount    int64     `json:"push_count"`
+	LastSourceIP string    `json:"last_source_ip,omitempty"`
+	// restauré depuis le stockage, pas encore revu depuis le démarrage
+	Stale bool `json:"stale"`
+	// fraîcheur online/stale/offline et cadence apprise (voir liveness.go)
+	Status           string  `json:"status"`
+	ExpectedInterval float64 `json:"expected_interval_seconds"`
+}
+
+type hostEntry struct {
+	data SystemData
+	meta HostMeta
+}
+
+// Registre des clients, sûr en accès concurrent ; les lectures renvoient des copies
+type Registry struct {
+	mu    sync.RWMutex
+	hosts map[string]*hostEntry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{hosts: make(map[string]*hostEntry)}
+}
+
+// Enregistre un envoi d'agent
+func (r *Registry) Update(data SystemData, sourceIP string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.hosts[data.Hostname]
+	if !ok {
+		entry = &hostEntry{meta: HostMeta{FirstSeen: now}}
+		r.hosts[data.Hostname] = entry
+	}
+	entry.data = data
+	entry.meta.LastSeen = now
+	entry.meta.PushCount++
+	entry.meta.LastSourceIP = sourceIP
+	entry.meta.Stale = false
+}
+
+// Réinjecte un instantané rechargé du stockage ; l'hôte reste "stale"
+// jusqu'à son prochain envoi
+func (r *Registry) Restore(data SystemData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.hosts[data.Hostname]; ok {
+		return
+	}
+	seen := snapshotTime(data)
+	r.hosts[data.Hostname] = &hostEntry{
+		data: data,
+		meta: HostMeta{FirstSeen: seen, LastSeen: seen, Stale: true},
+	}
+}
+
+// Dernier instantané et métadonnées d'un hôte
+func (r *Registry) Get(hostname string) (SystemData, HostMeta, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.hosts[hostname]
+	if !ok {
+		return SystemData{}, HostMeta{}, false
+	}
+	return copySystemData(entry.data), entry.meta, true
+}
+
+// Copie de l'état courant de tous les hôtes
+func (r *Registry) Snapshot() (map[string]SystemData, map[string]HostMeta) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clients := make(map[string]SystemData, len(r.hosts))
+	metas := make(map[string]HostMeta, len(r.hosts))
+	for hostname, entry := range r.hosts {
+		clients[hostname] = copySystemData(entry.data)
+		metas[hostname] = entry.meta
+	}
+	return clients, metas
+}
+
+// Copie de l'état courant, complétée par la fraîcheur de chaque hôte
+func clientsSnapshot() (map[string]SystemData, map[string]HostMeta) {
+	clients, metas := registry.Snapshot()
+	for hostname, meta := range metas {
+		status, interval := liveness.Status(hostname)
+		meta.Status = status
+		meta.ExpectedInterval = interval.Seconds()
+		metas[hostname] = meta
+	}
+	return clients, metas
+}
+
+func (r *Registry) Delete(hostname string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.hosts, hostname)
+}
+
+// Copie profonde : les tranches ne sont pas partagées avec l'appelant
+func copySystemData(data SystemData) SystemData {
+	data.CoreData = append([]CPUClientCoreData(nil), data.CoreData...)
+	data.Processes = append([]ProcessInfo(nil), data.Processes...)
+	return data
+}
+
+// Adresse IP de l'émetteur d'une requête
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
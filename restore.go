@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
+)
+
+// Fichier (ou ligne) qui n'a pas pu être relu au démarrage
+type LoadProblem struct {
+	Path string `json:"path"`
+	Line int    `json:"line,omitempty"`
+	Err  string `json:"error"`
+}
+
+// Bilan du rechargement de l'état au démarrage
+type LoadReport struct {
+	Restored map[string]SystemData
+	Problems []LoadProblem
+}
+
+// Reconstruit le dernier instantané connu de chaque hôte à partir d'infoPc :
+// segments de l'historique, puis anciens fichiers system_<hostname>_<nanos>.json
+func loadLatestSnapshots(dir string, h *HistoryStore) LoadReport {
+	report := LoadReport{Restored: make(map[string]SystemData)}
+
+	for _, hostname := range h.Hosts() {
+		for _, path := range h.segmentsNewestFirst(hostname) {
+			var latest *SystemData
+			err := readSegment(path, func(data SystemData) {
+				if latest == nil || !snapshotTime(data).Before(snapshotTime(*latest)) {
+					d := data
+					latest = &d
+				}
+			}, func(line int, err error) {
+				report.Problems = append(report.Problems, LoadProblem{Path: path, Line: line, Err: err.Error()})
+			})
+			if err != nil {
+				report.Problems = append(report.Problems, LoadProblem{Path: path, Err: err.Error()})
+			}
+			if latest != nil {
+				report.Restored[hostname] = *latest
+				break
+			}
+		}
+	}
+
+	legacy, err := filepath.Glob(filepath.Join(dir, "system_*.json"))
+	if err != nil {
+		report.Problems = append(report.Problems, LoadProblem{Path: dir, Err: err.Error()})
+		return report
+	}
+	for _, path := range legacy {
+		data, err := readLegacySnapshot(path)
+		if err != nil {
+			report.Problems = append(report.Problems, LoadProblem{Path: path, Err: err.Error()})
+			continue
+		}
+		current, ok := report.Restored[data.Hostname]
+		if !ok || snapshotTime(data).After(snapshotTime(current)) {
+			report.Restored[data.Hostname] = data
+		}
+	}
+	return report
+}
+
+// Lit un ancien fichier d'instantané (un SystemData indenté par fichier)
+func readLegacySnapshot(path string) (SystemData, error) {
+	var data SystemData
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return data, err
+	}
+	if err := protocol.Unmarshal(raw, &data); err != nil {
+		return data, fmt.Errorf("fichier corrompu ou partiel: %v", err)
+	}
+	if strings.TrimSpace(data.Hostname) == "" {
+		return data, fmt.Errorf("hostname absent")
+	}
+	// les plus anciens fichiers n'ont pas de collected_at : date du nom
+	if snapshotTime(data).IsZero() {
+		_, written, ok := parseLegacySnapshotName(filepath.Base(path))
+		if !ok {
+			return data, errNoTimestamp
+		}
+		data.CollectedAt = written.Format(time.RFC3339Nano)
+	}
+	return data, nil
+}
+
+// Recharge le registre au démarrage depuis le stockage ; les hôtes restaurés
+// restent "stale" jusqu'à leur prochain envoi
+func restoreClients(st Store) error {
+	latest, err := st.LatestAll()
+	if err != nil {
+		return err
+	}
+	for hostname, data := range latest {
+		registry.Restore(data)
+		liveness.Restore(hostname, snapshotTime(data))
+	}
+	fmt.Printf("♻️  %d client(s) restauré(s)\n", len(latest))
+	return nil
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Durée couverte par un fichier de rollup
+const rollupFileSpan = 24 * time.Hour
+
+// Nombre de processus résumés par agrégat
+const rollupTopProcesses = 10
+
+// Niveau d'agrégation : pas et durée de conservation
+type RollupTier struct {
+	Name string
+	Step time.Duration
+	Keep time.Duration
+}
+
+// Politique de rétention de l'historique
+type RetentionConfig struct {
+	Raw      time.Duration // conservation des instantanés bruts
+	Tiers    []RollupTier  // du plus fin au plus grossier
+	Interval time.Duration // période du compacteur
+}
+
+// Rétention en vigueur (options de la ligne de commande)
+var retention = defaultRetention()
+
+// Valeurs par défaut : 6h brut, 2 jours en 1m, 14 jours en 5m, 1 an en 1h
+func defaultRetention() RetentionConfig {
+	return RetentionConfig{
+		Raw: 6 * time.Hour,
+		Tiers: []RollupTier{
+			{Name: "1m", Step: time.Minute, Keep: 48 * time.Hour},
+			{Name: "5m", Step: 5 * time.Minute, Keep: 14 * 24 * time.Hour},
+			{Name: "1h", Step: time.Hour, Keep: 365 * 24 * time.Hour},
+		},
+		Interval: 10 * time.Minute,
+	}
+}
+
+// Agrégat statistique d'une série CPU
+type CPUAggregate struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	P95 float64 `json:"p95"`
+}
+
+// Résumé d'un processus sur un intervalle
+type ProcessSummary struct {
+	Name    string  `json:"name"`
+	Samples int     `json:"samples"`
+	AvgCPU  float64 `json:"avg_cpu"`
+	MaxCPU  float64 `json:"max_cpu"`
+	AvgMem  float64 `json:"avg_memory"`
+}
+
+// Agrégat d'un intervalle de temps pour un hôte
+type Rollup struct {
+	Start        time.Time            `json:"start"`
+	Step         int64                `json:"step"`
+	Samples      int                  `json:"samples"`
+	Cores        map[int]CPUAggregate `json:"cores"`
+	Average      CPUAggregate         `json:"average"`
+	TopProcesses []ProcessSummary     `json:"top_processes"`
+	Sources      []string             `json:"sources,omitempty"` // empreintes des segments agrégés
+}
+
+// Applique la politique de rétention ; Samples en tient compte pour les vieilles périodes
+func (h *HistoryStore) SetRetention(cfg RetentionConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retention = cfg
+}
+
+// Échantillons CPU d'un hôte : bruts quand ils existent encore, agrégats sinon
+func (h *HistoryStore) Samples(hostname string, from, to time.Time, step time.Duration) ([]cpuSample, error) {
+	snapshots, err := h.Query(hostname, from, to)
+	if err != nil {
+		return nil, err
+	}
+	samples := samplesFromSnapshots(snapshots)
+
+	h.mu.Lock()
+	tiers := h.retention.Tiers
+	h.mu.Unlock()
+	tier, ok := tierForStep(tiers, step)
+	if !ok {
+		return samples, nil
+	}
+
+	// Les agrégats ne couvrent que la période antérieure au premier brut
+	rollupTo := to
+	if len(samples) > 0 {
+		rollupTo = samples[0].ts.Add(-time.Nanosecond)
+		for _, sample := range samples {
+			if sample.ts.Before(rollupTo) {
+				rollupTo = sample.ts.Add(-time.Nanosecond)
+			}
+		}
+	}
+	if rollupTo.Before(from) {
+		return samples, nil
+	}
+	rollups, err := h.QueryRollups(hostname, tier, from, rollupTo)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rollups {
+		sample := cpuSample{ts: r.Start, cores: make(map[int]float64, len(r.Cores)), avg: r.Average.Avg}
+		for core, agg := range r.Cores {
+			sample.cores[core] = agg.Avg
+		}
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].ts.Before(samples[j].ts) })
+	return samples, nil
+}
+
+// Agrégats d'un niveau dont le début est dans [from, to] ; en cas de doublon
+// le dernier écrit, qui intègre les précédents, l'emporte
+func (h *HistoryStore) QueryRollups(hostname string, tier RollupTier, from, to time.Time) ([]Rollup, error) {
+	byStart := make(map[int64]Rollup)
+	for _, file := range h.rollupFiles(hostname, tier.Name) {
+		if file.start.After(to) || !file.start.Add(rollupFileSpan).After(from) {
+			continue
+		}
+		err := readRollupFile(file.path, func(r Rollup) {
+			if !r.Start.Before(from) && !r.Start.After(to) {
+				byStart[r.Start.Unix()] = r
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	rollups := make([]Rollup, 0, len(byStart))
+	for _, r := range byStart {
+		rollups = append(rollups, r)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Start.Before(rollups[j].Start) })
+	return rollups, nil
+}
+
+// Lance le compacteur en tâche de fond
+func startCompactor(st Store, interval time.Duration) {
+	go func() {
+		for {
+			if err := st.ApplyRetention(time.Now().UTC()); err != nil {
+				log.Printf("❌ Erreur compaction: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Agrège puis supprime les segments bruts expirés, et purge les agrégats trop vieux
+func (h *HistoryStore) Compact(now time.Time) error {
+	h.mu.Lock()
+	cfg := h.retention
+	h.mu.Unlock()
+
+	if err := h.importLegacyFiles(); err != nil {
+		return err
+	}
+
+	rawCutoff := now.Add(-cfg.Raw)
+	for _, hostname := range h.Hosts() {
+		for _, start := range h.expiredSegments(hostname, rawCutoff) {
+			if err := h.compactSegment(hostname, start, cfg.Tiers); err != nil {
+				return fmt.Errorf("%s: %v", hostname, err)
+			}
+		}
+		for _, tier := range cfg.Tiers {
+			cutoff := now.Add(-tier.Keep)
+			for _, file := range h.rollupFiles(hostname, tier.Name) {
+				if file.start.Add(rollupFileSpan).Before(cutoff) {
+					if err := os.Remove(file.path); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Segments entièrement antérieurs à cutoff
+func (h *HistoryStore) expiredSegments(hostname string, cutoff time.Time) []time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var expired []time.Time
+	for _, start := range h.index[hostname] {
+		if !start.Add(segmentSpan).After(cutoff) {
+			expired = append(expired, start)
+		}
+	}
+	return expired
+}
+
+// Écrit les agrégats de chaque niveau pour un segment, puis le supprime
+// Des données arrivées après la compaction de l'heure forment un nouveau
+// segment, fusionné avec les agrégats existants ; l'empreinte du segment
+// évite de le compter deux fois si une compaction interrompue est reprise
+func (h *HistoryStore) compactSegment(hostname string, start time.Time, tiers []RollupTier) error {
+	path := filepath.Join(h.hostDir(hostname), segmentName(start))
+	var snapshots []SystemData
+	if err := readSegment(path, func(data SystemData) {
+		snapshots = append(snapshots, data)
+	}, logBadLine(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	source, err := segmentDigest(path)
+	if err != nil {
+		return err
+	}
+
+	for _, tier := range tiers {
+		rollups := buildRollups(snapshots, tier.Step)
+		if len(rollups) == 0 {
+			continue
+		}
+		existing, err := h.QueryRollups(hostname, tier, rollups[0].Start, rollups[len(rollups)-1].Start)
+		if err != nil {
+			return err
+		}
+		byStart := make(map[int64]Rollup, len(existing))
+		for _, r := range existing {
+			byStart[r.Start.Unix()] = r
+		}
+		for i, r := range rollups {
+			r.Sources = []string{source}
+			if prev, ok := byStart[r.Start.Unix()]; ok {
+				r = mergeRollups(prev, r)
+			}
+			rollups[i] = r
+		}
+		if err := h.appendRollups(hostname, tier.Name, rollups); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if seg, ok := h.open[hostname]; ok && seg.start.Equal(start) {
+		seg.file.Close()
+		delete(h.open, hostname)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	h.removeFromIndex(hostname, start)
+	fmt.Printf("🗜️  Segment compacté: %s (%d instantanés)\n", path, len(snapshots))
+	return nil
+}
+
+// Empreinte du contenu d'un segment
+func segmentDigest(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (h *HistoryStore) removeFromIndex(hostname string, start time.Time) {
+	starts := h.index[hostname]
+	for i, s := range starts {
+		if s.Equal(start) {
+			h.index[hostname] = append(starts[:i], starts[i+1:]...)
+			break
+		}
+	}
+	if len(h.index[hostname]) == 0 && len(h.rollupFilesLocked(hostname, "")) == 0 {
+		delete(h.index, hostname)
+	}
+}
+
+func (h *HistoryStore) appendRollups(hostname, tier string, rollups []Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	files := make(map[time.Time]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, r := range rollups {
+		fileStart := r.Start.Truncate(rollupFileSpan)
+		file, ok := files[fileStart]
+		if !ok {
+			var err error
+			path := filepath.Join(h.hostDir(hostname), rollupFileName(tier, fileStart))
+			file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			if err := terminatePartialLine(path, file); err != nil {
+				file.Close()
+				return err
+			}
+			files[fileStart] = file
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Intègre les anciens fichiers system_<hostname>_<nanos>.json à l'historique
+// pour qu'ils soient soumis à la même rétention
+func (h *HistoryStore) importLegacyFiles() error {
+	legacy, err := filepath.Glob(filepath.Join(h.dir, "system_*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range legacy {
+		data, err := readLegacySnapshot(path)
+		if err != nil {
+			// laissé en place : le chargement au démarrage le signale
+			continue
+		}
+		if err := h.Append(data); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Agrège des instantanés par pas de temps
+func buildRollups(snapshots []SystemData, step time.Duration) []Rollup {
+	type procAcc struct {
+		samples int
+		cpuSum  float64
+		cpuMax  float64
+		memSum  float64
+	}
+	type acc struct {
+		cores map[int][]float64
+		avg   []float64
+		procs map[string]*procAcc
+	}
+	buckets := make(map[int64]*acc)
+
+	for _, data := range snapshots {
+		if len(data.CoreData) == 0 {
+			continue
+		}
+		key := snapshotTime(data).Truncate(step).Unix()
+		b, ok := buckets[key]
+		if !ok {
+			b = &acc{cores: make(map[int][]float64), procs: make(map[string]*procAcc)}
+			buckets[key] = b
+		}
+		total := 0.0
+		for _, core := range data.CoreData {
+			b.cores[core.Core] = append(b.cores[core.Core], core.CPUPercent)
+			total += core.CPUPercent
+		}
+		b.avg = append(b.avg, total/float64(len(data.CoreData)))
+
+		// Un même nom peut apparaître plusieurs fois : on cumule par instantané
+		perName := make(map[string]ProcessInfo)
+		for _, proc := range data.Processes {
+			cur := perName[proc.Name]
+			cur.CPUPercent += proc.CPUPercent
+			cur.MemPercent += proc.MemPercent
+			perName[proc.Name] = cur
+		}
+		for name, proc := range perName {
+			p, ok := b.procs[name]
+			if !ok {
+				p = &procAcc{}
+				b.procs[name] = p
+			}
+			p.samples++
+			p.cpuSum += proc.CPUPercent
+			p.memSum += float64(proc.MemPercent)
+			p.cpuMax = math.Max(p.cpuMax, proc.CPUPercent)
+		}
+	}
+
+	rollups := make([]Rollup, 0, len(buckets))
+	for key, b := range buckets {
+		r := Rollup{
+			Start:   time.Unix(key, 0).UTC(),
+			Step:    int64(step / time.Second),
+			Samples: len(b.avg),
+			Cores:   make(map[int]CPUAggregate, len(b.cores)),
+			Average: aggregate(b.avg),
+		}
+		for core, values := range b.cores {
+			r.Cores[core] = aggregate(values)
+		}
+		for name, p := range b.procs {
+			r.TopProcesses = append(r.TopProcesses, ProcessSummary{
+				Name:    name,
+				Samples: p.samples,
+				AvgCPU:  p.cpuSum / float64(p.samples),
+				MaxCPU:  p.cpuMax,
+				AvgMem:  p.memSum / float64(p.samples),
+			})
+		}
+		sortProcessSummaries(r.TopProcesses)
+		if len(r.TopProcesses) > rollupTopProcesses {
+			r.TopProcesses = r.TopProcesses[:rollupTopProcesses]
+		}
+		rollups = append(rollups, r)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Start.Before(rollups[j].Start) })
+	return rollups
+}
+
+// Fusionne deux agrégats du même intervalle (données arrivées après la
+// compaction) ; les moyennes sont pondérées par le nombre d'échantillons et
+// le 95e centile, qu'on ne peut recalculer sans les valeurs, garde le plus haut
+func mergeRollups(a, b Rollup) Rollup {
+	if len(b.Sources) > 0 && containsAll(a.Sources, b.Sources) {
+		return a // déjà intégré
+	}
+	merged := Rollup{
+		Start:   a.Start,
+		Step:    a.Step,
+		Samples: a.Samples + b.Samples,
+		Cores:   make(map[int]CPUAggregate, len(a.Cores)),
+		Average: mergeAggregates(a.Average, a.Samples, b.Average, b.Samples),
+		Sources: append(append([]string(nil), a.Sources...), b.Sources...),
+	}
+	for core, agg := range a.Cores {
+		merged.Cores[core] = agg
+	}
+	for core, agg := range b.Cores {
+		if cur, ok := merged.Cores[core]; ok {
+			agg = mergeAggregates(cur, a.Samples, agg, b.Samples)
+		}
+		merged.Cores[core] = agg
+	}
+
+	procs := make(map[string]ProcessSummary)
+	for _, p := range append(append([]ProcessSummary(nil), a.TopProcesses...), b.TopProcesses...) {
+		cur, ok := procs[p.Name]
+		if !ok {
+			procs[p.Name] = p
+			continue
+		}
+		n := cur.Samples + p.Samples
+		cur.AvgCPU = (cur.AvgCPU*float64(cur.Samples) + p.AvgCPU*float64(p.Samples)) / float64(n)
+		cur.AvgMem = (cur.AvgMem*float64(cur.Samples) + p.AvgMem*float64(p.Samples)) / float64(n)
+		cur.MaxCPU = math.Max(cur.MaxCPU, p.MaxCPU)
+		cur.Samples = n
+		procs[p.Name] = cur
+	}
+	for _, p := range procs {
+		merged.TopProcesses = append(merged.TopProcesses, p)
+	}
+	sortProcessSummaries(merged.TopProcesses)
+	if len(merged.TopProcesses) > rollupTopProcesses {
+		merged.TopProcesses = merged.TopProcesses[:rollupTopProcesses]
+	}
+	return merged
+}
+
+func containsAll(set, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, s := range set {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeAggregates(a CPUAggregate, na int, b CPUAggregate, nb int) CPUAggregate {
+	if na+nb == 0 {
+		return a
+	}
+	return CPUAggregate{
+		Min: math.Min(a.Min, b.Min),
+		Avg: (a.Avg*float64(na) + b.Avg*float64(nb)) / float64(na+nb),
+		Max: math.Max(a.Max, b.Max),
+		P95: math.Max(a.P95, b.P95),
+	}
+}
+
+// Par CPU moyen décroissant, puis par nom pour un ordre stable
+func sortProcessSummaries(procs []ProcessSummary) {
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].AvgCPU != procs[j].AvgCPU {
+			return procs[i].AvgCPU > procs[j].AvgCPU
+		}
+		return procs[i].Name < procs[j].Name
+	})
+}
+
+// Min, moyenne, max et 95e centile (rang le plus proche)
+func aggregate(values []float64) CPUAggregate {
+	if len(values) == 0 {
+		return CPUAggregate{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	return CPUAggregate{
+		Min: sorted[0],
+		Avg: sum / float64(len(sorted)),
+		Max: sorted[len(sorted)-1],
+		P95: percentile(sorted, 95),
+	}
+}
+
+// Centile p d'une série triée (rang le plus proche)
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Pas de lecture d'une fenêtre se terminant maintenant : celui du palier
+// le plus fin conservé assez longtemps pour la couvrir, ou du plus long
+func stepForWindow(tiers []RollupTier, window time.Duration) time.Duration {
+	var step, longest, longestKeep time.Duration
+	for _, tier := range tiers {
+		if tier.Keep >= window && (step == 0 || tier.Step < step) {
+			step = tier.Step
+		}
+		if tier.Keep > longestKeep {
+			longest, longestKeep = tier.Step, tier.Keep
+		}
+	}
+	if step == 0 {
+		return longest
+	}
+	return step
+}
+
+// Niveau le plus grossier dont le pas ne dépasse pas step (à défaut le plus fin)
+func tierForStep(tiers []RollupTier, step time.Duration) (RollupTier, bool) {
+	if len(tiers) == 0 {
+		return RollupTier{}, false
+	}
+	best := tiers[0]
+	for _, tier := range tiers {
+		if tier.Step <= step && tier.Step > best.Step {
+			best = tier
+		}
+	}
+	return best, true
+}
+
+type rollupFile struct {
+	path  string
+	start time.Time
+}
+
+func (h *HistoryStore) rollupFiles(hostname, tier string) []rollupFile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rollupFilesLocked(hostname, tier)
+}
+
+// Fichiers d'agrégats d'un hôte (tous niveaux si tier est vide)
+func (h *HistoryStore) rollupFilesLocked(hostname, tier string) []rollupFile {
+	entries, err := os.ReadDir(h.hostDir(hostname))
+	if err != nil {
+		return nil
+	}
+	var files []rollupFile
+	for _, entry := range entries {
+		name, start, ok := parseRollupFileName(entry.Name())
+		if !ok || (tier != "" && name != tier) {
+			continue
+		}
+		files = append(files, rollupFile{path: filepath.Join(h.hostDir(hostname), entry.Name()), start: start})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].start.Before(files[j].start) })
+	return files
+}
+
+func readRollupFile(path string, fn func(Rollup)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var r Rollup
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Printf("⚠️  Ligne %d illisible dans %s: %v", line, path, err)
+			continue
+		}
+		fn(r)
+	}
+	return scanner.Err()
+}
+
+func rollupFileName(tier string, start time.Time) string {
+	return fmt.Sprintf("rollup_%s_%d.jsonl", tier, start.Unix())
+}
+
+func parseRollupFileName(name string) (string, time.Time, bool) {
+	if !strings.HasPrefix(name, "rollup_") || !strings.HasSuffix(name, ".jsonl") {
+		return "", time.Time{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, "rollup_"), ".jsonl"), "_")
+	if len(parts) != 2 {
+		return "", time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[0], time.Unix(secs, 0).UTC(), true
+}
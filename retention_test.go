@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStepForWindow(t *testing.T) {
+	tiers := defaultRetention().Tiers
+	tests := []struct {
+		window time.Duration
+		want   time.Duration
+	}{
+		{time.Hour, time.Minute},
+		{48 * time.Hour, time.Minute},
+		{72 * time.Hour, 5 * time.Minute},
+		{30 * 24 * time.Hour, time.Hour},
+		// au-delà de toute conservation : le palier le plus long
+		{2 * 365 * 24 * time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := stepForWindow(tiers, tt.window); got != tt.want {
+			t.Errorf("stepForWindow(%s) = %s, attendu %s", tt.window, got, tt.want)
+		}
+		if tier, _ := tierForStep(tiers, stepForWindow(tiers, tt.window)); tier.Keep < tt.window && tier.Keep < tiers[len(tiers)-1].Keep {
+			t.Errorf("fenêtre %s lue dans le palier %s conservé %s", tt.window, tier.Name, tier.Keep)
+		}
+	}
+}
+
+// Un segment arrivé après la compaction de son heure complète l'agrégat ;
+// reprendre une compaction interrompue ne le compte pas deux fois
+func TestCompactMergesLateSegments(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-24 * time.Hour)
+	segment := filepath.Join(dir, storageKey("web"), segmentName(old))
+
+	h, err := OpenHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetRetention(defaultRetention())
+	steps := []struct {
+		name    string
+		cpu     []float64
+		replay  bool // segment remis en place après compaction
+		samples int
+		avg     float64
+	}{
+		{"premier segment", []float64{10, 20}, false, 2, 15},
+		{"données en retard", []float64{60}, true, 3, 30},
+		{"compaction reprise", nil, false, 3, 30},
+	}
+	var saved []byte
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			for i, cpu := range step.cpu {
+				if err := h.Append(testSnapshot("web", old.Add(time.Duration(len(step.cpu)*10+i)*time.Second), cpu)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if step.cpu == nil {
+				// segment déjà agrégé mais resté sur disque
+				if err := os.WriteFile(segment, saved, 0644); err != nil {
+					t.Fatal(err)
+				}
+				h.Close()
+				if h, err = OpenHistoryStore(dir); err != nil {
+					t.Fatal(err)
+				}
+				h.SetRetention(defaultRetention())
+			}
+			if err := h.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if step.replay {
+				if saved, err = os.ReadFile(segment); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := h.Compact(now); err != nil {
+				t.Fatal(err)
+			}
+			rollups, err := h.QueryRollups("web", defaultRetention().Tiers[0], old, old)
+			if err != nil || len(rollups) != 1 {
+				t.Fatalf("QueryRollups = %d, %v", len(rollups), err)
+			}
+			if r := rollups[0]; r.Samples != step.samples || r.Average.Avg != step.avg {
+				t.Errorf("agrégat = %d échantillons, moyenne %.1f ; attendu %d, %.1f",
+					r.Samples, r.Average.Avg, step.samples, step.avg)
+			}
+		})
+	}
+	h.Close()
+}
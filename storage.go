@@ -4,31 +4,88 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
 )
 
-// Stockage en mémoire
-var clientsData = make(map[string]SystemData)
+// État courant des clients
+var registry = NewRegistry()
+
+// Fraîcheur des clients
+var liveness = NewLiveness(defaultLivenessConfig())
+
+// Règles d'alerte évaluées à l'ingestion
+var alerts = NewAlertEngine(nil)
+
+// Envoi des alertes (nil si aucun canal n'est configuré)
+var notifier *Notifier
+
+// Diffusion des mises à jour aux tableaux de bord
+var events = NewEventHub()
+
+// Clés des agents (nil si l'authentification est désactivée)
+var agentKeys *AgentKeyStore
+
+// Stockage persistant (fichier, mémoire ou SQL)
+var store Store
+
+// Journal d'ingestion
+var ingestWAL *WAL
+
+// Limites de débit et file d'ingestion (nil : sans limite, écriture directe)
+var (
+	limiter     *RateLimiter
+	ingestQueue *IngestQueue
+)
+
+// Rend un instantané durable (journal synchronisé) puis l'ajoute au stockage
+func persistSnapshot(systemData SystemData) error {
+	payload, err := json.Marshal(systemData)
+	if err != nil {
+		return err
+	}
+	return ingestWAL.Ingest(payload, func() error {
+		return saveSystemData(systemData)
+	})
+}
 
 // Sauvegarde sur disque
-func saveSystemData(systemData SystemData) {
-	filename := filepath.Join("infoPc", fmt.Sprintf("system_%s_%d.json",
-		systemData.Hostname, time.Now().UnixNano()))
-	
-	file, err := os.Create(filename)
+func saveSystemData(systemData SystemData) error {
+	if err := store.SaveSnapshot(systemData); err != nil {
+		return fmt.Errorf("écriture stockage: %v", err)
+	}
+	fmt.Printf("💾 Sauvegardé: %s (avec %d processus)\n", systemData.Hostname, len(systemData.Processes))
+	return nil
+}
+
+// Rejoue le journal au démarrage ; les instantanés déjà présents dans
+// le stockage (appliqués avant l'arrêt) sont ignorés, comme ceux qu'on ne
+// sait plus décoder (signalés sans bloquer le démarrage)
+func replayWAL(w *WAL, st Store) error {
+	applied, skipped := 0, 0
+	count, err := w.Replay(func(payload []byte) error {
+		var systemData SystemData
+		err := protocol.Unmarshal(payload, &systemData)
+		if err == nil && snapshotTime(systemData).IsZero() {
+			err = errNoTimestamp
+		}
+		if err != nil {
+			skipped++
+			log.Printf("⚠️  Journal %s: enregistrement illisible ignoré: %v", w.path, err)
+			return nil
+		}
+		found, err := st.Contains(systemData)
+		if err != nil || found {
+			return err
+		}
+		applied++
+		return st.SaveSnapshot(systemData)
+	})
 	if err != nil {
-		log.Printf("❌ Erreur création fichier: %v", err)
-		return
+		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(systemData); err != nil {
-		log.Printf("❌ Erreur écriture fichier: %v", err)
-	} else {
-		fmt.Printf("💾 Sauvegardé: %s (avec %d processus)\n", filename, len(systemData.Processes))
+	if count > 0 {
+		fmt.Printf("📜 Journal rejoué: %d enregistrement(s), %d réappliqué(s), %d ignoré(s)\n", count, applied, skipped)
 	}
+	return w.Checkpoint(st.Sync)
 }
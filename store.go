@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Stockage persistant des instantanés : état courant, historique, rétention.
+// Les handlers ne dépendent que de cette interface.
+type Store interface {
+	// Enregistre un instantané (historique + dernier état de l'hôte)
+	SaveSnapshot(data SystemData) error
+	// Dernier instantané connu d'un hôte
+	Latest(hostname string) (SystemData, bool, error)
+	// Dernier instantané de chaque hôte
+	LatestAll() (map[string]SystemData, error)
+	// Instantanés bruts d'un hôte dans [from, to]
+	Snapshots(hostname string, from, to time.Time) ([]SystemData, error)
+	// Échantillons CPU d'un hôte, bruts ou agrégés selon le pas demandé
+	History(hostname string, from, to time.Time, step time.Duration) ([]cpuSample, error)
+	// Indique si un instantané identique (même hôte, même contenu) est déjà
+	// stocké ; sert à ne pas réappliquer un enregistrement du journal
+	Contains(data SystemData) (bool, error)
+	// Applique la politique de rétention (agrégation puis purge)
+	ApplyRetention(now time.Time) error
+	// Supprime toutes les données d'un hôte
+	DeleteHost(hostname string) error
+	// Rend durables les écritures en cours
+	Sync() error
+	Close() error
+}
+
+// Vrai si deux instantanés ont le même contenu ; la version du schéma est
+// ignorée (mise à niveau à la relecture)
+func sameSnapshot(a, b SystemData) bool {
+	return a.Hostname == b.Hostname && a.CollectedAt == b.CollectedAt && snapshotDigest(a) == snapshotDigest(b)
+}
+
+func snapshotDigest(data SystemData) [sha256.Size]byte {
+	data.SchemaVersion = 0
+	payload, _ := json.Marshal(data)
+	return sha256.Sum256(payload)
+}
+
+// Options de sélection du stockage
+type StoreConfig struct {
+	Kind      string // file, memory ou sql
+	Dir       string // répertoire du stockage fichier
+	Driver    string // pilote database/sql (doit être lié au binaire)
+	DSN       string
+	Retention RetentionConfig
+}
+
+// Ouvre le stockage demandé
+func openStore(cfg StoreConfig) (Store, error) {
+	switch cfg.Kind {
+	case "file", "":
+		return OpenFileStore(cfg.Dir, cfg.Retention)
+	case "memory":
+		return NewMemoryStore(cfg.Retention), nil
+	case "sql":
+		return OpenSQLStore(cfg.Driver, cfg.DSN, cfg.Retention)
+	default:
+		return nil, fmt.Errorf("stockage inconnu: %q", cfg.Kind)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stockage fichier : historique segmenté dans infoPc (voir history.go)
+// et dernier état de chaque hôte gardé en mémoire
+type FileStore struct {
+	*HistoryStore
+
+	mu     sync.RWMutex
+	latest map[string]SystemData
+}
+
+// Ouvre le stockage et recharge le dernier instantané de chaque hôte ;
+// les fichiers corrompus ou partiels sont signalés
+func OpenFileStore(dir string, retention RetentionConfig) (*FileStore, error) {
+	h, err := OpenHistoryStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	h.SetRetention(retention)
+
+	report := loadLatestSnapshots(dir, h)
+	for _, problem := range report.Problems {
+		if problem.Line > 0 {
+			log.Printf("⚠️  %s (ligne %d) ignoré: %s", problem.Path, problem.Line, problem.Err)
+		} else {
+			log.Printf("⚠️  %s ignoré: %s", problem.Path, problem.Err)
+		}
+	}
+	return &FileStore{HistoryStore: h, latest: report.Restored}, nil
+}
+
+func (f *FileStore) SaveSnapshot(data SystemData) error {
+	if err := f.Append(data); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if current, ok := f.latest[data.Hostname]; !ok || !snapshotTime(data).Before(snapshotTime(current)) {
+		f.latest[data.Hostname] = data
+	}
+	return nil
+}
+
+func (f *FileStore) Latest(hostname string) (SystemData, bool, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	data, ok := f.latest[hostname]
+	return data, ok, nil
+}
+
+func (f *FileStore) LatestAll() (map[string]SystemData, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	latest := make(map[string]SystemData, len(f.latest))
+	for hostname, data := range f.latest {
+		latest[hostname] = data
+	}
+	return latest, nil
+}
+
+func (f *FileStore) Snapshots(hostname string, from, to time.Time) ([]SystemData, error) {
+	return f.Query(hostname, from, to)
+}
+
+func (f *FileStore) History(hostname string, from, to time.Time, step time.Duration) ([]cpuSample, error) {
+	return f.Samples(hostname, from, to, step)
+}
+
+func (f *FileStore) ApplyRetention(now time.Time) error {
+	return f.Compact(now)
+}
+
+// Supprime le répertoire de l'hôte (segments et agrégats)
+func (f *FileStore) DeleteHost(hostname string) error {
+	f.HistoryStore.mu.Lock()
+	if seg, ok := f.open[hostname]; ok {
+		seg.file.Close()
+		delete(f.open, hostname)
+	}
+	delete(f.index, hostname)
+	f.HistoryStore.mu.Unlock()
+
+	f.mu.Lock()
+	delete(f.latest, hostname)
+	f.mu.Unlock()
+
+	if err := os.RemoveAll(f.hostDir(hostname)); err != nil {
+		return err
+	}
+	// anciens fichiers : nom exact, "web" ne doit pas emporter "web_prod"
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	key := storageKey(hostname)
+	for _, entry := range entries {
+		if k, _, ok := parseLegacySnapshotName(entry.Name()); !ok || k != key {
+			continue
+		}
+		if err := os.Remove(filepath.Join(f.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clé d'hôte et date d'écriture d'un ancien fichier system_<hôte>_<nanos>.json
+func parseLegacySnapshotName(name string) (string, time.Time, bool) {
+	rest, ok := strings.CutPrefix(name, "system_")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	rest, ok = strings.CutSuffix(rest, ".json")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	i := strings.LastIndexByte(rest, '_')
+	if i <= 0 || i == len(rest)-1 {
+		return "", time.Time{}, false
+	}
+	for _, c := range rest[i+1:] {
+		if c < '0' || c > '9' {
+			return "", time.Time{}, false
+		}
+	}
+	nanos, err := strconv.ParseInt(rest[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return rest[:i], time.Unix(0, nanos).UTC(), true
+}
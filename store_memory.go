@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stockage en mémoire, pour les tests et les déploiements sans disque ;
+// seuls les instantanés bruts sont gardés, pendant retention.Raw
+type MemoryStore struct {
+	mu        sync.RWMutex
+	snapshots map[string][]SystemData // triés par collected_at
+	retention RetentionConfig
+}
+
+func NewMemoryStore(retention RetentionConfig) *MemoryStore {
+	return &MemoryStore{
+		snapshots: make(map[string][]SystemData),
+		retention: retention,
+	}
+}
+
+func (m *MemoryStore) SaveSnapshot(data SystemData) error {
+	ts := snapshotTime(data)
+	if ts.IsZero() {
+		return errNoTimestamp
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.snapshots[data.Hostname]
+	i := sort.Search(len(list), func(i int) bool { return snapshotTime(list[i]).After(ts) })
+	list = append(list, SystemData{})
+	copy(list[i+1:], list[i:])
+	list[i] = data
+	m.snapshots[data.Hostname] = list
+	return nil
+}
+
+func (m *MemoryStore) Latest(hostname string) (SystemData, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := m.snapshots[hostname]
+	if len(list) == 0 {
+		return SystemData{}, false, nil
+	}
+	return list[len(list)-1], true, nil
+}
+
+func (m *MemoryStore) LatestAll() (map[string]SystemData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	latest := make(map[string]SystemData, len(m.snapshots))
+	for hostname, list := range m.snapshots {
+		if len(list) > 0 {
+			latest[hostname] = list[len(list)-1]
+		}
+	}
+	return latest, nil
+}
+
+func (m *MemoryStore) Snapshots(hostname string, from, to time.Time) ([]SystemData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []SystemData
+	for _, data := range m.snapshots[hostname] {
+		ts := snapshotTime(data)
+		if !ts.Before(from) && !ts.After(to) {
+			result = append(result, data)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) History(hostname string, from, to time.Time, step time.Duration) ([]cpuSample, error) {
+	snapshots, err := m.Snapshots(hostname, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return samplesFromSnapshots(snapshots), nil
+}
+
+func (m *MemoryStore) Contains(data SystemData) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, stored := range m.snapshots[data.Hostname] {
+		if sameSnapshot(stored, data) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Supprime les instantanés plus vieux que retention.Raw (le dernier de chaque hôte est gardé)
+func (m *MemoryStore) ApplyRetention(now time.Time) error {
+	cutoff := now.Add(-m.retention.Raw)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hostname, list := range m.snapshots {
+		i := sort.Search(len(list), func(i int) bool { return !snapshotTime(list[i]).Before(cutoff) })
+		if i >= len(list) {
+			i = len(list) - 1
+		}
+		m.snapshots[hostname] = append([]SystemData(nil), list[i:]...)
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteHost(hostname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshots, hostname)
+	return nil
+}
+
+func (m *MemoryStore) Sync() error  { return nil }
+func (m *MemoryStore) Close() error { return nil }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Tenkydo/monprojet/protocol"
+	// pilote SQLite embarqué (cgo), enregistré sous le nom "sqlite3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Migrations du schéma SQL, appliquées dans l'ordre et une seule fois.
+// Le SQL vise SQLite : types simples, paramètres "?" et upserts
+// "ON CONFLICT" (SQLite 3.24 et plus) ; un autre pilote peut demander
+// d'adapter les paramètres.
+var sqlMigrations = []string{
+	// plusieurs instantanés peuvent partager un horodatage : clé propre
+	`CREATE TABLE snapshots (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		hostname     TEXT    NOT NULL,
+		collected_at INTEGER NOT NULL,
+		data         TEXT    NOT NULL
+	)`,
+	`CREATE TABLE latest (
+		hostname     TEXT    PRIMARY KEY,
+		collected_at INTEGER NOT NULL,
+		data         TEXT    NOT NULL
+	)`,
+	`CREATE TABLE rollups (
+		hostname TEXT    NOT NULL,
+		tier     TEXT    NOT NULL,
+		start    INTEGER NOT NULL,
+		data     TEXT    NOT NULL,
+		PRIMARY KEY (hostname, tier, start)
+	)`,
+	`CREATE INDEX snapshots_host_time ON snapshots (hostname, collected_at)`,
+}
+
+// Stockage database/sql ; seul le pilote SQLite est lié au binaire, un
+// autre pilote doit y être importé pour que sql.Open le trouve
+type SQLStore struct {
+	db        *sql.DB
+	retention RetentionConfig
+}
+
+// Ouvre la base et applique les migrations manquantes
+func OpenSQLStore(driver, dsn string, retention RetentionConfig) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &SQLStore{db: db, retention: retention}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migration: %v", err)
+	}
+	return s, nil
+}
+
+func (s *SQLStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqlMigrations[version-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("version %d: %v", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) SaveSnapshot(data SystemData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if snapshotTime(data).IsZero() {
+		return errNoTimestamp
+	}
+	ts := snapshotTime(data).UnixNano()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO snapshots (hostname, collected_at, data) VALUES (?, ?, ?)`,
+		data.Hostname, ts, string(payload)); err != nil {
+		return err
+	}
+	// le dernier état n'est remplacé que par un instantané plus récent
+	if _, err := tx.Exec(`INSERT INTO latest (hostname, collected_at, data) VALUES (?, ?, ?)
+		ON CONFLICT (hostname) DO UPDATE SET collected_at = excluded.collected_at, data = excluded.data
+		WHERE excluded.collected_at >= latest.collected_at`,
+		data.Hostname, ts, string(payload)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Latest(hostname string) (SystemData, bool, error) {
+	var data SystemData
+	var payload string
+	err := s.db.QueryRow(`SELECT data FROM latest WHERE hostname = ?`, hostname).Scan(&payload)
+	if err == sql.ErrNoRows {
+		return data, false, nil
+	}
+	if err != nil {
+		return data, false, err
+	}
+	err = protocol.Unmarshal([]byte(payload), &data)
+	return data, err == nil, err
+}
+
+func (s *SQLStore) LatestAll() (map[string]SystemData, error) {
+	rows, err := s.db.Query(`SELECT hostname, data FROM latest`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	latest := make(map[string]SystemData)
+	for rows.Next() {
+		var hostname, payload string
+		if err := rows.Scan(&hostname, &payload); err != nil {
+			return nil, err
+		}
+		var data SystemData
+		if err := protocol.Unmarshal([]byte(payload), &data); err != nil {
+			return nil, fmt.Errorf("%s: %v", hostname, err)
+		}
+		latest[hostname] = data
+	}
+	return latest, rows.Err()
+}
+
+func (s *SQLStore) Snapshots(hostname string, from, to time.Time) ([]SystemData, error) {
+	rows, err := s.db.Query(`SELECT data FROM snapshots WHERE hostname = ? AND collected_at BETWEEN ? AND ? ORDER BY collected_at, id`,
+		hostname, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []SystemData
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return nil, err
+		}
+		var data SystemData
+		if err := protocol.Unmarshal([]byte(payload), &data); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, rows.Err()
+}
+
+// Échantillons bruts, complétés par les agrégats pour la période antérieure
+func (s *SQLStore) History(hostname string, from, to time.Time, step time.Duration) ([]cpuSample, error) {
+	snapshots, err := s.Snapshots(hostname, from, to)
+	if err != nil {
+		return nil, err
+	}
+	samples := samplesFromSnapshots(snapshots)
+
+	tier, ok := tierForStep(s.retention.Tiers, step)
+	if !ok {
+		return samples, nil
+	}
+	rollupTo := to
+	if len(samples) > 0 {
+		rollupTo = samples[0].ts.Add(-time.Nanosecond)
+	}
+	rows, err := s.db.Query(`SELECT data FROM rollups WHERE hostname = ? AND tier = ? AND start BETWEEN ? AND ? ORDER BY start`,
+		hostname, tier.Name, from.Unix(), rollupTo.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return nil, err
+		}
+		var r Rollup
+		if err := json.Unmarshal([]byte(payload), &r); err != nil {
+			return nil, err
+		}
+		sample := cpuSample{ts: r.Start, cores: make(map[int]float64, len(r.Cores)), avg: r.Average.Avg}
+		for core, agg := range r.Cores {
+			sample.cores[core] = agg.Avg
+		}
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].ts.Before(samples[j].ts) })
+	return samples, rows.Err()
+}
+
+func (s *SQLStore) Contains(data SystemData) (bool, error) {
+	rows, err := s.db.Query(`SELECT data FROM snapshots WHERE hostname = ? AND collected_at = ?`,
+		data.Hostname, snapshotTime(data).UnixNano())
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return false, err
+		}
+		var stored SystemData
+		if err := protocol.Unmarshal([]byte(payload), &stored); err != nil {
+			return false, err
+		}
+		if sameSnapshot(stored, data) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Agrège les instantanés bruts expirés heure par heure, puis les supprime
+func (s *SQLStore) ApplyRetention(now time.Time) error {
+	rawCutoff := now.Add(-s.retention.Raw).Truncate(segmentSpan)
+
+	rows, err := s.db.Query(`SELECT DISTINCT hostname FROM snapshots WHERE collected_at < ?`, rawCutoff.UnixNano())
+	if err != nil {
+		return err
+	}
+	var hosts []string
+	for rows.Next() {
+		var hostname string
+		if err := rows.Scan(&hostname); err != nil {
+			rows.Close()
+			return err
+		}
+		hosts = append(hosts, hostname)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, hostname := range hosts {
+		if err := s.compact(hostname, rawCutoff); err != nil {
+			return fmt.Errorf("%s: %v", hostname, err)
+		}
+	}
+
+	for _, tier := range s.retention.Tiers {
+		if _, err := s.db.Exec(`DELETE FROM rollups WHERE tier = ? AND start < ?`,
+			tier.Name, now.Add(-tier.Keep).Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lit, agrège et supprime dans la même transaction : un instantané reçu
+// entre-temps n'est pas supprimé sans avoir été agrégé
+func (s *SQLStore) compact(hostname string, cutoff time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT data FROM snapshots WHERE hostname = ? AND collected_at < ?`,
+		hostname, cutoff.UnixNano())
+	if err != nil {
+		return err
+	}
+	var expired []SystemData
+	for rows.Next() {
+		var payload string
+		var data SystemData
+		if err := rows.Scan(&payload); err == nil {
+			err = protocol.Unmarshal([]byte(payload), &data)
+		}
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, data)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, tier := range s.retention.Tiers {
+		for _, r := range buildRollups(expired, tier.Step) {
+			// des données en retard complètent l'agrégat existant
+			var existing string
+			err := tx.QueryRow(`SELECT data FROM rollups WHERE hostname = ? AND tier = ? AND start = ?`,
+				hostname, tier.Name, r.Start.Unix()).Scan(&existing)
+			switch {
+			case err == nil:
+				var prev Rollup
+				if err := json.Unmarshal([]byte(existing), &prev); err != nil {
+					return err
+				}
+				r = mergeRollups(prev, r)
+			case err != sql.ErrNoRows:
+				return err
+			}
+			payload, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO rollups (hostname, tier, start, data) VALUES (?, ?, ?, ?)
+				ON CONFLICT (hostname, tier, start) DO UPDATE SET data = excluded.data`,
+				hostname, tier.Name, r.Start.Unix(), string(payload)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM snapshots WHERE hostname = ? AND collected_at < ?`,
+		hostname, cutoff.UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteHost(hostname string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"snapshots", "latest", "rollups"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE hostname = ?`, hostname); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Les écritures sont validées par transaction : rien à faire
+func (s *SQLStore) Sync() error { return nil }
+
+func (s *SQLStore) Close() error { return s.db.Close() }
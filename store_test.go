@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSnapshot(hostname string, at time.Time, cpu float64) SystemData {
+	return SystemData{
+		SchemaVersion: 2,
+		Hostname:      hostname,
+		CollectedAt:   at.UTC().Format(time.RFC3339),
+		CoreData:      []CPUClientCoreData{{Core: 0, CPUPercent: cpu, Timestamp: at.UTC().Format(time.RFC3339)}},
+	}
+}
+
+// Stockages soumis au même contrat
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	retention := defaultRetention()
+	stores := map[string]Store{"memory": NewMemoryStore(retention)}
+
+	sqlStore, err := OpenSQLStore("sqlite3", filepath.Join(t.TempDir(), "monitor.db"), retention)
+	if err != nil {
+		t.Fatalf("OpenSQLStore: %v", err)
+	}
+	stores["sql"] = sqlStore
+
+	fileStore, err := OpenFileStore(t.TempDir(), retention)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	stores["file"] = fileStore
+
+	for _, st := range stores {
+		t.Cleanup(func() { st.Close() })
+	}
+	return stores
+}
+
+func TestStoreContract(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			first := testSnapshot("web", base, 10)
+			second := testSnapshot("web", base.Add(time.Minute), 20)
+			other := testSnapshot("db", base, 30)
+			// même seconde que first, contenu différent : les deux sont conservés
+			twin := testSnapshot("web", base, 15)
+			// enregistrés dans le désordre : le dernier état reste le plus récent
+			for _, data := range []SystemData{second, first, other, twin} {
+				if err := st.SaveSnapshot(data); err != nil {
+					t.Fatalf("SaveSnapshot: %v", err)
+				}
+			}
+
+			latest, ok, err := st.Latest("web")
+			if err != nil || !ok {
+				t.Fatalf("Latest = %v, %v", ok, err)
+			}
+			if latest.CollectedAt != second.CollectedAt {
+				t.Errorf("Latest.CollectedAt = %s, attendu %s", latest.CollectedAt, second.CollectedAt)
+			}
+
+			all, err := st.LatestAll()
+			if err != nil || len(all) != 2 {
+				t.Fatalf("LatestAll = %d hôtes, %v", len(all), err)
+			}
+
+			snaps, err := st.Snapshots("web", base, base.Add(time.Hour))
+			if err != nil || len(snaps) != 3 {
+				t.Fatalf("Snapshots = %d, %v", len(snaps), err)
+			}
+
+			for _, data := range []SystemData{first, twin} {
+				if found, err := st.Contains(data); err != nil || !found {
+					t.Errorf("Contains(%s, %.0f%%) = %v, %v", data.CollectedAt, data.CoreData[0].CPUPercent, found, err)
+				}
+			}
+			if found, err := st.Contains(testSnapshot("web", base.Add(2*time.Minute), 10)); err != nil || found {
+				t.Errorf("Contains(inconnu) = %v, %v", found, err)
+			}
+
+			// sans collected_at, l'instantané ne peut pas être rangé
+			undated := testSnapshot("nodate", base, 40)
+			undated.CollectedAt = ""
+			if err := st.SaveSnapshot(undated); err != errNoTimestamp {
+				t.Errorf("SaveSnapshot(sans date) = %v, attendu %v", err, errNoTimestamp)
+			}
+			if _, ok, _ := st.Latest("nodate"); ok {
+				t.Errorf("instantané sans date enregistré")
+			}
+
+			if err := st.DeleteHost("web"); err != nil {
+				t.Fatalf("DeleteHost: %v", err)
+			}
+			if _, ok, _ := st.Latest("web"); ok {
+				t.Errorf("web encore présent après DeleteHost")
+			}
+			if _, ok, _ := st.Latest("db"); !ok {
+				t.Errorf("db supprimé par DeleteHost(web)")
+			}
+		})
+	}
+}
+
+// Les anciens fichiers d'un hôte dont le nom prolonge celui d'un autre
+// (web / web_prod) ne sont pas supprimés avec lui
+func TestFileStoreDeleteHostLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	st, err := OpenFileStore(dir, defaultRetention())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	names := []string{"system_web_1700000000000000000.json", "system_web_prod_1700000000000000000.json", "system_web_notes.json"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.DeleteHost("web"); err != nil {
+		t.Fatalf("DeleteHost: %v", err)
+	}
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if removed := os.IsNotExist(err); removed != (i == 0) {
+			t.Errorf("%s: supprimé = %v", name, removed)
+		}
+	}
+}
+
+// Des données en retard pour une heure déjà compactée complètent l'agrégat
+// au lieu de le remplacer
+func TestSQLStoreMergesLateRollups(t *testing.T) {
+	st, err := OpenSQLStore("sqlite3", filepath.Join(t.TempDir(), "monitor.db"), defaultRetention())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-24 * time.Hour)
+
+	for _, data := range []SystemData{testSnapshot("web", old, 10), testSnapshot("web", old.Add(time.Second), 20)} {
+		if err := st.SaveSnapshot(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.ApplyRetention(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveSnapshot(testSnapshot("web", old.Add(2*time.Second), 60)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ApplyRetention(now); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload string
+	if err := st.db.QueryRow(`SELECT data FROM rollups WHERE hostname = ? AND tier = ? AND start = ?`,
+		"web", "1m", old.Unix()).Scan(&payload); err != nil {
+		t.Fatal(err)
+	}
+	var r Rollup
+	if err := json.Unmarshal([]byte(payload), &r); err != nil {
+		t.Fatal(err)
+	}
+	want := CPUAggregate{Min: 10, Avg: 30, Max: 60, P95: 60}
+	if r.Samples != 3 || r.Average != want {
+		t.Errorf("agrégat = %d échantillons, %+v ; attendu 3, %+v", r.Samples, r.Average, want)
+	}
+}
+
+// Seuls les répertoires contenant des segments ou des agrégats sont des hôtes
+func TestOpenHistoryStoreIndexesHostsOnly(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"web/seg_1700000000.jsonl":         "",
+		"db/rollup_1h_1699920000.jsonl":    "",
+		"notify/webhook.queue.json":        "[]",
+		"empty/notes.txt":                  "",
+		"system_web_1700000000000000.json": "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := OpenHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if got := h.Hosts(); len(got) != 2 || got[0] != "db" || got[1] != "web" {
+		t.Errorf("Hosts() = %v, attendu [db web]", got)
+	}
+}
+
+// Un ancien fichier sans collected_at est daté par son nom
+func TestReadLegacySnapshotDatesFromName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system_web_1700000000000000000.json")
+	if err := os.WriteFile(path, []byte(`{"hostname":"web"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readLegacySnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1700000000, 0).UTC(); !snapshotTime(data).Equal(want) {
+		t.Errorf("snapshotTime = %s, attendu %s", snapshotTime(data), want)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Configuration HTTPS du serveur
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	ClientCA   string // CA des certificats d'agents (mTLS)
+	ClientAuth string // request (vérifié si présenté) ou require
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Certificat du serveur gardé en mémoire, rechargé quand le certificat ou
+// la clé change sur disque, ou sur SIGHUP ; tant que la nouvelle paire est
+// illisible (renouvellement en cours), l'ancienne reste servie
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // dernière modification déjà prise en compte
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	r.modTime = r.latestModTime()
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// Plus récente modification du certificat ou de la clé
+func (r *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	modTime := r.latestModTime()
+	r.mu.Lock()
+	changed := modTime.After(r.modTime)
+	if changed {
+		r.modTime = modTime
+	}
+	r.mu.Unlock()
+	if changed {
+		if err := r.reload(); err != nil {
+			log.Printf("⚠️  Certificat TLS non rechargé, l'ancien reste utilisé: %v", err)
+		} else {
+			fmt.Printf("🔒 Certificat TLS rechargé\n")
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// Recharge le certificat à chaque SIGHUP
+func (r *certReloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := r.reload(); err != nil {
+				log.Printf("⚠️  Certificat TLS non rechargé (SIGHUP): %v", err)
+				continue
+			}
+			fmt.Printf("🔒 Certificat TLS rechargé (SIGHUP)\n")
+		}
+	}()
+}
+
+// Construit la configuration TLS ; le certificat est gardé en mémoire et
+// rechargé à son renouvellement, sans redémarrage
+func buildServerTLS(c TLSConfig) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("-tls-cert et -tls-key vont ensemble")
+	}
+	certs, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	certs.watchSignals()
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if c.ClientCA == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(c.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: aucun certificat PEM", c.ClientCA)
+	}
+	cfg.ClientCAs = pool
+	switch c.ClientAuth {
+	case "", "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("-tls-client-auth doit valoir request ou require")
+	}
+	return cfg, nil
+}
+
+// Identité portée par un certificat client vérifié : le CN est le nom d'hôte
+func certificateIdentity(r *http.Request) (AgentIdentity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return AgentIdentity{}, false
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if cn == "" {
+		return AgentIdentity{}, false
+	}
+	return AgentIdentity{KeyID: "cert:" + cn, Hostname: cn}, true
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+// Cookie de session du tableau de bord
+const sessionCookie = "cpumon_session"
+
+// En-tête portant le jeton anti-CSRF
+const headerCSRF = "X-CSRF-Token"
+
+// Comptes et sessions du tableau de bord
+var (
+	users    *user.Store
+	sessions *user.Sessions
+)
+
+// Chaque vérification argon2id coûte 64 Mio : tentatives limitées par
+// adresse et nombre de calculs simultanés borné
+var (
+	loginLimiter = NewRateLimiter(defaultLoginLimitConfig())
+	loginSlots   = make(chan struct{}, 4)
+)
+
+// Attente maximale d'un créneau de vérification avant refus (503)
+const loginSlotWait = 5 * time.Second
+
+func defaultLoginLimitConfig() RateLimitConfig {
+	return RateLimitConfig{HostRate: 0.2, HostBurst: 5}
+}
+
+// Empreinte vérifiée pour un identifiant inconnu : même coût que pour un
+// compte existant, la durée de réponse ne révèle pas les comptes
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := user.HashPassword("cpumon-compte-inexistant")
+	if err != nil {
+		log.Fatalf("❌ Empreinte de référence impossible: %v", err)
+	}
+	return hash
+})
+
+// Vérifie des identifiants dans un créneau de calcul ; errLoginBusy si
+// aucun ne se libère à temps
+func checkCredentials(r *http.Request, login, password string) (user.User, bool, error) {
+	timer := time.NewTimer(loginSlotWait)
+	defer timer.Stop()
+	select {
+	case loginSlots <- struct{}{}:
+		defer func() { <-loginSlots }()
+	case <-timer.C:
+		return user.User{}, false, errLoginBusy
+	case <-r.Context().Done():
+		return user.User{}, false, r.Context().Err()
+	}
+	u, ok := users.Get(login)
+	if !ok {
+		user.VerifyPassword(dummyPasswordHash(), password)
+		return user.User{}, false, nil
+	}
+	return u, u.CheckPassword(password), nil
+}
+
+var errLoginBusy = &throttleError{status: http.StatusServiceUnavailable, retryAfter: loginSlotWait, reason: "trop de connexions en cours"}
+
+// Session et compte correspondant au cookie de la requête
+func sessionFrom(r *http.Request) (user.Session, user.User, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return user.Session{}, user.User{}, false
+	}
+	s, ok := sessions.Get(cookie.Value, time.Now())
+	if !ok {
+		return user.Session{}, user.User{}, false
+	}
+	u, ok := users.Get(s.Login)
+	if !ok {
+		sessions.Close(s.Token)
+		return user.Session{}, user.User{}, false
+	}
+	return s, u, true
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Vérifie que la requête vient de la même origine (formulaire de connexion)
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// Connexion : GET affiche la page, POST ouvre une session
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		http.ServeFile(w, r, "static2/login.html")
+		return
+	case http.MethodPost:
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+		return
+	}
+	if !sameOrigin(r) {
+		writeJSONError(w, http.StatusForbidden, "origine refusée")
+		return
+	}
+	if err := loginLimiter.AllowSource(sourceIP(r), time.Now()); err != nil {
+		log.Printf("🚫 Tentatives de connexion limitées depuis %s", sourceIP(r))
+		setRetryAfter(w, err)
+		writeJSONError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	var creds struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Impossible de décoder le JSON")
+			return
+		}
+	} else {
+		creds.Login, creds.Password = r.FormValue("login"), r.FormValue("password")
+	}
+
+	u, ok, err := checkCredentials(r, creds.Login, creds.Password)
+	if err != nil {
+		setRetryAfter(w, err)
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !ok {
+		log.Printf("🚫 Échec de connexion pour %q depuis %s", creds.Login, sourceIP(r))
+		writeJSONError(w, http.StatusUnauthorized, "identifiants invalides")
+		return
+	}
+
+	s := sessions.Open(u.Login, time.Now())
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	fmt.Printf("👤 %s connecté\n", u.Login)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": u.Public(), "csrf_token": s.CSRF})
+}
+
+// Déconnexion
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessions.Close(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Appelant courant ; pour une session, aussi son jeton CSRF
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r)
+	response := map[string]interface{}{"via": p.Via}
+	if u, ok := users.Get(p.Login); ok {
+		response["user"] = u.Public()
+	} else {
+		response["user"] = map[string]interface{}{"login": p.Login, "role": p.Role, "groups": p.Groups}
+	}
+	if s, _, ok := sessionFrom(r); ok && p.Via == "session" {
+		response["csrf_token"] = s.CSRF
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Champs acceptés par l'API d'administration des comptes
+type userRequest struct {
+	Name     *string   `json:"name"`
+	Login    string    `json:"login"`
+	Email    *string   `json:"email"`
+	Age      *int      `json:"age"`
+	Password *string   `json:"password"`
+	Role     *string   `json:"role"`
+	Groups   *[]string `json:"groups"`
+}
+
+func (req userRequest) apply(u *user.User) error {
+	if req.Name != nil {
+		u.UpdateName(*req.Name)
+	}
+	if req.Email != nil {
+		u.UpdateEmail(*req.Email)
+	}
+	if req.Age != nil {
+		u.UpdateAge(*req.Age)
+	}
+	if req.Role != nil {
+		role, err := user.ParseRole(*req.Role)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidUser, err)
+		}
+		u.Role = role
+	}
+	if req.Groups != nil {
+		for _, g := range *req.Groups {
+			if _, ok := hostGroups[g]; !ok && g != user.AllGroups {
+				return fmt.Errorf("%w: groupe d'hôtes inconnu: %q", errInvalidUser, g)
+			}
+		}
+		u.Groups = *req.Groups
+	}
+	if req.Password != nil {
+		return u.SetPassword(*req.Password)
+	}
+	return nil
+}
+
+var errInvalidUser = errors.New("compte invalide")
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrNotFound), errors.Is(err, errKeyNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, user.ErrExists):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, user.ErrInvalidLogin), errors.Is(err, user.ErrWeakPassword), errors.Is(err, errInvalidUser):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// API d'administration des comptes : GET liste, POST crée,
+// PATCH ?login= modifie, DELETE ?login= supprime
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	login := r.URL.Query().Get("login")
+	switch r.Method {
+	case http.MethodGet:
+		list := []user.Public{}
+		for _, u := range users.List() {
+			list = append(list, u.Public())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": list})
+
+	case http.MethodPost:
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Impossible de décoder le JSON")
+			return
+		}
+		if req.Password == nil {
+			writeJSONError(w, http.StatusBadRequest, "password requis")
+			return
+		}
+		u := user.New(req.Login, req.Login, "", 0)
+		if err := req.apply(u); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if err := users.Create(u); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		fmt.Printf("👤 Compte %s créé\n", u.Login)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(u.Public())
+
+	case http.MethodPatch, http.MethodPut:
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Impossible de décoder le JSON")
+			return
+		}
+		updated, err := users.Update(login, req.apply)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		// un changement de mot de passe ou de droits ferme les sessions ouvertes
+		if req.Password != nil || req.Role != nil || req.Groups != nil {
+			sessions.CloseUser(login)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated.Public())
+
+	case http.MethodDelete:
+		if principalFrom(r).Login == login {
+			writeJSONError(w, http.StatusBadRequest, "impossible de supprimer son propre compte")
+			return
+		}
+		if err := users.Delete(login); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		sessions.CloseUser(login)
+		fmt.Printf("👤 Compte %s supprimé\n", login)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted", "login": login})
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Méthode non autorisée")
+	}
+}
+
+// Fichier (0600) recevant le mot de passe admin généré au premier démarrage
+const adminPasswordFile = "admin_password"
+
+// Crée un compte admin au premier démarrage ; le mot de passe vient de
+// $CPU_MONITOR_ADMIN_PASSWORD ou est généré et écrit une seule fois dans
+// dir/admin_password, jamais dans les journaux
+func ensureAdminUser(dir string) error {
+	if users.Len() > 0 {
+		return nil
+	}
+	password := os.Getenv("CPU_MONITOR_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(b)
+	}
+	u := user.New("Administrateur", "admin", "", 0)
+	u.Role = user.RoleAdmin
+	u.Groups = []string{user.AllGroups}
+	if err := u.SetPassword(password); err != nil {
+		return err
+	}
+	if err := users.Create(u); err != nil {
+		return err
+	}
+	if generated {
+		path := filepath.Join(dir, adminPasswordFile)
+		if err := writeSecretFile(path, password+"\n"); err != nil {
+			users.Delete(u.Login)
+			return fmt.Errorf("mot de passe admin: %v", err)
+		}
+		log.Printf("🔐 Compte admin créé, mot de passe initial dans %s (à supprimer après la première connexion)", path)
+	} else {
+		log.Printf("🔐 Compte admin créé avec $CPU_MONITOR_ADMIN_PASSWORD")
+	}
+	return nil
+}
+
+// Écrit un secret dans un nouveau fichier lisible par le seul propriétaire
+func writeSecretFile(path, secret string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(secret); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
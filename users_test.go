@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tenkydo/monprojet/oldall/user"
+)
+
+func setupLogin(t *testing.T) {
+	t.Helper()
+	st, err := user.OpenStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := user.New("Alice", "alice", "", 0)
+	if err := u.SetPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	users, sessions = st, user.NewSessions(time.Hour)
+	loginLimiter = NewRateLimiter(RateLimitConfig{HostRate: 0.01, HostBurst: 3})
+	loginSlots = make(chan struct{}, 1)
+}
+
+func postLogin(login, password, remote string) *httptest.ResponseRecorder {
+	body := `{"login":"` + login + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	handleLogin(rec, req)
+	return rec
+}
+
+func TestLogin(t *testing.T) {
+	setupLogin(t)
+	tests := []struct {
+		name, login, password string
+		want                  int
+	}{
+		{"compte valide", "alice", "correct horse", http.StatusOK},
+		{"mauvais mot de passe", "alice", "wrong horse", http.StatusUnauthorized},
+		{"compte inconnu", "bob", "correct horse", http.StatusUnauthorized},
+		// rafale de 3 épuisée pour cette adresse
+		{"limite atteinte", "alice", "correct horse", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postLogin(tt.login, tt.password, "192.0.2.1:1234")
+			if rec.Code != tt.want {
+				t.Fatalf("statut %d, attendu %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After absent")
+			}
+		})
+	}
+	// une autre adresse n'est pas concernée
+	if rec := postLogin("alice", "correct horse", "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("autre adresse: statut %d", rec.Code)
+	}
+}
+
+// Sans créneau de vérification libre, la connexion est refusée (503)
+func TestLoginBusy(t *testing.T) {
+	setupLogin(t)
+	loginSlots <- struct{}{}
+	defer func() { <-loginSlots }()
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"login":"alice","password":"correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(req.Context(), 50*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handleLogin(rec, req.WithContext(ctx))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("statut %d, attendu 503", rec.Code)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 )
 
 // Logs lisibles
@@ -46,15 +48,187 @@ func logSystemData(systemData SystemData) {
 	fmt.Println("   ─────────────────────────")
 }
 
-// Stats globales
-func computeStats(data map[string]SystemData) map[string]interface{} {
-	// même logique que ton computeStats original
-	// ...
-	return nil // à compléter ici si nécessaire
+// Nombre de cœurs renvoyés dans hottest_cores
+const hottestCoresLimit = 10
+
+// Stats globales : samples donne, par hôte, les échantillons à agréger
+// (historique d'une fenêtre) ; s'il est nil seul le dernier instantané compte
+func computeStats(data map[string]SystemData, metas map[string]HostMeta, samples map[string][]cpuSample, now time.Time) FleetStats {
+	if samples == nil {
+		samples = make(map[string][]cpuSample, len(data))
+		for hostname, systemData := range data {
+			samples[hostname] = samplesFromSnapshots([]SystemData{systemData})
+		}
+	}
+
+	stats := FleetStats{
+		HostCount:    len(data),
+		HottestCores: []CoreLoad{},
+		ByOS:         make(map[string]Breakdown),
+		ByPlatform:   make(map[string]Breakdown),
+		ByVendor:     make(map[string]Breakdown),
+		ByModel:      make(map[string]Breakdown),
+		ComputedAt:   now.Format(time.RFC3339),
+	}
+
+	// tous les hôtes sont comptés ; la moyenne ne porte que sur ceux qui
+	// ont des échantillons dans la fenêtre
+	type group struct {
+		hosts, sampled int
+		sum            float64
+	}
+	groups := map[string]map[string]*group{"os": {}, "platform": {}, "vendor": {}, "model": {}}
+	var hostAverages []float64
+
+	for hostname, systemData := range data {
+		if metas[hostname].Status == StatusOnline {
+			stats.OnlineCount++
+		}
+
+		hostSamples := samples[hostname]
+		hostAvg := 0.0
+		if len(hostSamples) > 0 {
+			coreSums := make(map[int]float64)
+			coreCounts := make(map[int]int)
+			hostSum := 0.0
+			for _, sample := range hostSamples {
+				hostSum += sample.avg
+				for core, value := range sample.cores {
+					coreSums[core] += value
+					coreCounts[core]++
+				}
+			}
+			hostAvg = hostSum / float64(len(hostSamples))
+			hostAverages = append(hostAverages, hostAvg)
+			for core, sum := range coreSums {
+				stats.HottestCores = append(stats.HottestCores, CoreLoad{
+					Hostname:   hostname,
+					Core:       core,
+					CPUPercent: sum / float64(coreCounts[core]),
+				})
+			}
+		}
+
+		for dimension, key := range map[string]string{
+			"os":       systemData.OS,
+			"platform": systemData.Platform,
+			"vendor":   systemData.CPUInfo.VendorID,
+			"model":    systemData.CPUInfo.Model,
+		} {
+			if key == "" {
+				key = "unknown"
+			}
+			g, ok := groups[dimension][key]
+			if !ok {
+				g = &group{}
+				groups[dimension][key] = g
+			}
+			g.hosts++
+			if len(hostSamples) > 0 {
+				g.sampled++
+				g.sum += hostAvg
+			}
+		}
+	}
+	stats.SampledCount = len(hostAverages)
+
+	sort.Float64s(hostAverages)
+	if len(hostAverages) > 0 {
+		sum := 0.0
+		for _, v := range hostAverages {
+			sum += v
+		}
+		stats.CPU = CPUStats{
+			Avg:    sum / float64(len(hostAverages)),
+			Median: median(hostAverages),
+			P95:    percentile(hostAverages, 95),
+		}
+	}
+
+	sort.Slice(stats.HottestCores, func(i, j int) bool {
+		return stats.HottestCores[i].CPUPercent > stats.HottestCores[j].CPUPercent
+	})
+	if len(stats.HottestCores) > hottestCoresLimit {
+		stats.HottestCores = stats.HottestCores[:hottestCoresLimit]
+	}
+
+	for dimension, target := range map[string]map[string]Breakdown{
+		"os":       stats.ByOS,
+		"platform": stats.ByPlatform,
+		"vendor":   stats.ByVendor,
+		"model":    stats.ByModel,
+	} {
+		for key, g := range groups[dimension] {
+			b := Breakdown{Hosts: g.hosts}
+			if g.sampled > 0 {
+				b.AvgCPU = g.sum / float64(g.sampled)
+			}
+			target[key] = b
+		}
+	}
+	return stats
+}
+
+// Médiane d'une série triée
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
 }
 
+// Nombre de processus dans les classements top CPU / top mémoire
+const topProcessesLimit = 10
+
 // Stats processus
 func computeProcessStats(processes []ProcessInfo) ProcessStats {
-	// même logique que ton computeProcessStats original
-	return ProcessStats{}
+	stats := ProcessStats{
+		TotalProcesses: len(processes),
+		TopCPUProcesses: topProcesses(processes, func(a, b ProcessInfo) bool {
+			return a.CPUPercent > b.CPUPercent
+		}),
+		TopMemProcesses: topProcesses(processes, func(a, b ProcessInfo) bool {
+			return a.MemPercent > b.MemPercent
+		}),
+	}
+	for _, proc := range processes {
+		switch processState(proc.Status) {
+		case "running":
+			stats.RunningProcs++
+		case "sleeping":
+			stats.SleepingProcs++
+		case "zombie":
+			stats.ZombieProcs++
+		}
+	}
+	return stats
+}
+
+func topProcesses(processes []ProcessInfo, less func(a, b ProcessInfo) bool) []ProcessInfo {
+	sorted := append([]ProcessInfo(nil), processes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	if len(sorted) > topProcessesLimit {
+		sorted = sorted[:topProcessesLimit]
+	}
+	return sorted
+}
+
+// Normalise le statut gopsutil ("R", "running", "S", "sleep", "Z"...)
+func processState(status string) string {
+	switch strings.ToLower(status) {
+	case "r", "running":
+		return "running"
+	case "s", "sleep", "sleeping", "i", "idle", "d", "disk-sleep":
+		return "sleeping"
+	case "z", "zombie":
+		return "zombie"
+	case "t", "stop", "stopped":
+		return "stopped"
+	default:
+		return strings.ToLower(status)
+	}
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Un hôte sans échantillon dans la fenêtre reste compté dans les
+// répartitions, sans peser sur les moyennes
+func TestComputeStatsHostsWithoutSamples(t *testing.T) {
+	now := time.Now().UTC()
+	data := map[string]SystemData{
+		"web": {Hostname: "web", OS: "linux"},
+		"db":  {Hostname: "db", OS: "linux"},
+		"old": {Hostname: "old", OS: "windows"},
+	}
+	samples := map[string][]cpuSample{
+		"web": {{ts: now, avg: 40, cores: map[int]float64{0: 40}}},
+		"db":  {{ts: now, avg: 20, cores: map[int]float64{0: 20}}},
+	}
+	stats := computeStats(data, map[string]HostMeta{}, samples, now)
+
+	if stats.HostCount != 3 || stats.SampledCount != 2 {
+		t.Errorf("host_count = %d, sampled_count = %d", stats.HostCount, stats.SampledCount)
+	}
+	for name, breakdown := range map[string]map[string]Breakdown{"os": stats.ByOS, "vendor": stats.ByVendor} {
+		hosts := 0
+		for _, b := range breakdown {
+			hosts += b.Hosts
+		}
+		if hosts != stats.HostCount {
+			t.Errorf("by_%s compte %d hôtes, host_count %d", name, hosts, stats.HostCount)
+		}
+	}
+	if got := stats.ByOS["linux"]; got != (Breakdown{Hosts: 2, AvgCPU: 30}) {
+		t.Errorf("by_os[linux] = %+v", got)
+	}
+	if got := stats.ByOS["windows"]; got != (Breakdown{Hosts: 1}) {
+		t.Errorf("by_os[windows] = %+v", got)
+	}
+	if stats.CPU.Avg != 30 {
+		t.Errorf("cpu.avg = %v, attendu 30", stats.CPU.Avg)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Limites appliquées aux envois des agents
+type IngestLimits struct {
+	MaxBody      int64         // octets, avant et après décompression
+	MaxCores     int           // cœurs par instantané
+	MaxProcesses int           // processus par instantané
+	MaxClockSkew time.Duration // avance tolérée des horodatages sur l'horloge du serveur
+}
+
+var ingestLimits = IngestLimits{
+	MaxBody:      8 << 20,
+	MaxCores:     1024,
+	MaxProcesses: 20000,
+	MaxClockSkew: 10 * time.Minute,
+}
+
+// Nom d'hôte : labels DNS (le _ est toléré pour les noms NetBIOS)
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9_](?:[A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?(?:\.[A-Za-z0-9_](?:[A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?)*$`)
+
+const maxHostnameLength = 253
+
+// Champ rejeté, repéré par son chemin (ex. core_data[3].cpu_percent)
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Instantané rejeté par la validation
+type ValidationError struct {
+	Hostname string
+	Fields   []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "données invalides: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func validateHostname(hostname string) error {
+	switch {
+	case hostname == "":
+		return errors.New("requis")
+	case len(hostname) > maxHostnameLength:
+		return fmt.Errorf("plus de %d caractères", maxHostnameLength)
+	case !hostnamePattern.MatchString(hostname):
+		return errors.New("caractères ou format invalides")
+	}
+	return nil
+}
+
+// Pourcentage fini dans [0, max]
+func checkPercent(e *ValidationError, field string, v, max float64) {
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		e.add(field, "valeur non finie")
+	case v < 0:
+		e.add(field, "négatif (%g)", v)
+	case v > max:
+		e.add(field, "supérieur à %g (%g)", max, v)
+	}
+}
+
+// Horodatage RFC3339 facultatif, pas trop en avance sur le serveur
+func checkTimestamp(e *ValidationError, field, value string, now time.Time) {
+	if value == "" {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		e.add(field, "horodatage RFC3339 attendu")
+		return
+	}
+	if ts.After(now.Add(ingestLimits.MaxClockSkew)) {
+		e.add(field, "dans le futur (%s)", value)
+	}
+}
+
+// Valide un instantané avant ingestion ; retourne un *ValidationError
+func ValidateSnapshot(data SystemData, now time.Time) error {
+	e := &ValidationError{Hostname: data.Hostname}
+	if err := validateHostname(data.Hostname); err != nil {
+		e.add("hostname", "%v", err)
+	}
+	checkTimestamp(e, "collected_at", data.CollectedAt, now)
+
+	if len(data.CoreData) > ingestLimits.MaxCores {
+		e.add("core_data", "%d cœurs, maximum %d", len(data.CoreData), ingestLimits.MaxCores)
+	} else {
+		seen := make(map[int]bool, len(data.CoreData))
+		for i, core := range data.CoreData {
+			field := fmt.Sprintf("core_data[%d]", i)
+			if core.Core < 0 || core.Core >= ingestLimits.MaxCores {
+				e.add(field+".core", "indice hors limites (%d)", core.Core)
+			} else if seen[core.Core] {
+				e.add(field+".core", "cœur %d en double", core.Core)
+			}
+			seen[core.Core] = true
+			checkPercent(e, field+".cpu_percent", core.CPUPercent, 100)
+			checkTimestamp(e, field+".timestamp", core.Timestamp, now)
+		}
+	}
+
+	if len(data.Processes) > ingestLimits.MaxProcesses {
+		e.add("processes", "%d processus, maximum %d", len(data.Processes), ingestLimits.MaxProcesses)
+	} else {
+		// un processus multi-thread peut dépasser 100 % : borne à 100 % par cœur
+		cores := len(data.CoreData)
+		if cores == 0 {
+			cores = ingestLimits.MaxCores
+		}
+		for i, p := range data.Processes {
+			field := fmt.Sprintf("processes[%d]", i)
+			if p.PID < 0 {
+				e.add(field+".pid", "négatif (%d)", p.PID)
+			}
+			if p.NumThreads < 0 {
+				e.add(field+".num_threads", "négatif (%d)", p.NumThreads)
+			}
+			if p.CreateTime < 0 {
+				e.add(field+".create_time", "négatif (%d)", p.CreateTime)
+			}
+			checkPercent(e, field+".cpu_percent", p.CPUPercent, float64(100*cores))
+			checkPercent(e, field+".memory_percent", float64(p.MemPercent), 100)
+		}
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// Valide un lot d'instantanés, vérifie que l'identité de l'agent couvre
+// chaque hôte puis applique la limite par hôte ; le lot est rejeté en entier
+// à la première erreur
+func checkSnapshots(r *http.Request, snapshots []SystemData, now time.Time) (int, error) {
+	for _, systemData := range snapshots {
+		if err := ValidateSnapshot(systemData, now); err != nil {
+			counters.validationErrors.Add(1)
+			log.Printf("❌ Instantané rejeté (%q): %v", systemData.Hostname, err)
+			return http.StatusBadRequest, err
+		}
+		if err := authorizeHost(r, systemData.Hostname); err != nil {
+			counters.authFailures.Add(1)
+			return http.StatusForbidden, err
+		}
+		if err := limiter.AllowHost(systemData.Hostname, now); err != nil {
+			return http.StatusTooManyRequests, err
+		}
+	}
+	return 0, nil
+}
+
+// Borne la taille du corps d'une requête d'ingestion
+func limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, ingestLimits.MaxBody)
+}
+
+// Statut HTTP d'une erreur de lecture du corps : 413 si la limite est atteinte
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// Erreur JSON structurée ; les erreurs de validation détaillent les champs
+func writeIngestError(w http.ResponseWriter, status int, err error) {
+	setRetryAfter(w, err)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    "données invalides",
+		"hostname": invalid.Hostname,
+		"fields":   invalid.Fields,
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		valid    bool
+	}{
+		{"web-1", true},
+		{"web-1.eu.example.com", true},
+		{"PC_COMPTA", true},
+		{strings.Repeat("a", 63), true},
+		{"", false},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a.", 127) + "a", false},
+		{"-web", false},
+		{"web-", false},
+		{"web..1", false},
+		{"../etc", false},
+		{"web/1", false},
+		{"web 1", false},
+	}
+	for _, tt := range tests {
+		if err := validateHostname(tt.hostname); (err == nil) != tt.valid {
+			t.Errorf("validateHostname(%q) = %v, valide attendu: %v", tt.hostname, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	saved := ingestLimits
+	defer func() { ingestLimits = saved }()
+	ingestLimits.MaxCores = 4
+	ingestLimits.MaxProcesses = 3
+
+	now := time.Date(2025, 9, 3, 12, 0, 0, 0, time.UTC)
+	valid := func() SystemData {
+		return SystemData{
+			Hostname:    "web-1",
+			CollectedAt: now.Format(time.RFC3339),
+			CoreData:    []CPUClientCoreData{{Core: 0, CPUPercent: 12.5}, {Core: 1, CPUPercent: 100}},
+			Processes:   []ProcessInfo{{PID: 1, CPUPercent: 150, MemPercent: 2}},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(d *SystemData)
+		fields []string // champs rejetés, dans l'ordre
+	}{
+		{"valide", func(d *SystemData) {}, nil},
+		{"sans horodatage", func(d *SystemData) { d.CollectedAt = "" }, nil},
+		{"avance tolérée", func(d *SystemData) { d.CollectedAt = now.Add(5 * time.Minute).Format(time.RFC3339) }, nil},
+		{"nom d'hôte", func(d *SystemData) { d.Hostname = "../web" }, []string{"hostname"}},
+		{"dans le futur", func(d *SystemData) { d.CollectedAt = now.Add(time.Hour).Format(time.RFC3339) }, []string{"collected_at"}},
+		{"horodatage illisible", func(d *SystemData) { d.CoreData[1].Timestamp = "hier" }, []string{"core_data[1].timestamp"}},
+		{"trop de cœurs", func(d *SystemData) {
+			d.CoreData = make([]CPUClientCoreData, 5)
+			for i := range d.CoreData {
+				d.CoreData[i].Core = i
+			}
+		}, []string{"core_data"}},
+		{"indice de cœur", func(d *SystemData) { d.CoreData[1].Core = 4 }, []string{"core_data[1].core"}},
+		{"cœur en double", func(d *SystemData) { d.CoreData[1].Core = 0 }, []string{"core_data[1].core"}},
+		{"pourcentages", func(d *SystemData) {
+			d.CoreData[0].CPUPercent = -1
+			d.CoreData[1].CPUPercent = math.NaN()
+		}, []string{"core_data[0].cpu_percent", "core_data[1].cpu_percent"}},
+		{"processus au-delà de 100 % par cœur", func(d *SystemData) { d.Processes[0].CPUPercent = 201 }, []string{"processes[0].cpu_percent"}},
+		{"processus négatifs", func(d *SystemData) {
+			d.Processes[0] = ProcessInfo{PID: -1, NumThreads: -1, CreateTime: -1, MemPercent: 101}
+		}, []string{"processes[0].pid", "processes[0].num_threads", "processes[0].create_time", "processes[0].memory_percent"}},
+		{"trop de processus", func(d *SystemData) { d.Processes = make([]ProcessInfo, 4) }, []string{"processes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := valid()
+			tt.modify(&data)
+			err := ValidateSnapshot(data, now)
+			var fields []string
+			if err != nil {
+				var invalid *ValidationError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("erreur %T, attendu *ValidationError", err)
+				}
+				for _, f := range invalid.Fields {
+					fields = append(fields, f.Field)
+				}
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("champs rejetés = %v, attendu %v (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}
+
+func TestBodyErrorStatus(t *testing.T) {
+	saved := ingestLimits
+	defer func() { ingestLimits = saved }()
+	ingestLimits.MaxBody = 8
+
+	tests := []struct {
+		name string
+		body string
+		want int // 0 : lecture complète
+	}{
+		{"dans la limite", "12345678", 0},
+		{"trop volumineux", "123456789", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/cpu", strings.NewReader(tt.body))
+			limitBody(httptest.NewRecorder(), req)
+			_, err := io.ReadAll(req.Body)
+			got := 0
+			if err != nil {
+				got = bodyErrorStatus(err)
+			}
+			if got != tt.want {
+				t.Errorf("statut %d, attendu %d (%v)", got, tt.want, err)
+			}
+		})
+	}
+	if got := bodyErrorStatus(io.ErrUnexpectedEOF); got != http.StatusBadRequest {
+		t.Errorf("autre erreur: statut %d, attendu 400", got)
+	}
+}
+
+func TestWriteIngestError(t *testing.T) {
+	invalid := &ValidationError{Hostname: "web-1"}
+	invalid.add("core_data[0].cpu_percent", "négatif (%g)", -1.0)
+
+	tests := []struct {
+		name string
+		err  error
+		want map[string]interface{}
+	}{
+		{"validation", invalid, map[string]interface{}{
+			"error":    "données invalides",
+			"hostname": "web-1",
+			"fields":   []interface{}{map[string]interface{}{"field": "core_data[0].cpu_percent", "message": "négatif (-1)"}},
+		}},
+		{"autre erreur", errors.New("JSON invalide"), map[string]interface{}{"error": "JSON invalide"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeIngestError(rec, http.StatusBadRequest, tt.err)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("statut %d", rec.Code)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("corps = %v, attendu %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Un nom d'hôte ne peut pas désigner un autre répertoire que le sien
+func TestStorageKey(t *testing.T) {
+	tests := []struct {
+		hostname, want string
+	}{
+		{"web-1.example.com", "web-1.example.com"},
+		{"PC_COMPTA", "PC_COMPTA"},
+		{"..", "%2E."},
+		{".hidden", "%2Ehidden"},
+		{"../etc", "%2E.%2Fetc"},
+		{`a\b`, "a%5Cb"},
+		{"", "%00"},
+	}
+	for _, tt := range tests {
+		got := storageKey(tt.hostname)
+		if got != tt.want {
+			t.Errorf("storageKey(%q) = %q, attendu %q", tt.hostname, got, tt.want)
+		}
+		if tt.hostname != "" && hostFromKey(got) != tt.hostname {
+			t.Errorf("hostFromKey(%q) = %q, attendu %q", got, hostFromKey(got), tt.hostname)
+		}
+	}
+}
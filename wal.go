@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// En-tête d'un enregistrement : longueur (uint32) + CRC32-C (uint32), little endian
+const walHeaderSize = 8
+
+// Taille maximale d'un enregistrement accepté à la relecture
+const walMaxRecord = 64 * 1024 * 1024
+
+// Nombre maximal d'enregistrements par fsync groupé
+const walMaxBatch = 256
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errWALClosed = errors.New("journal fermé")
+
+// Journal d'ingestion (write-ahead log) : un envoi n'est acquitté qu'une fois
+// son enregistrement écrit et synchronisé sur disque
+type WAL struct {
+	path   string
+	file   *os.File
+	window time.Duration
+
+	// les ingestions tiennent le verrou en lecture entre Append et leur
+	// application ; le checkpoint le prend en écriture
+	ingestMu sync.RWMutex
+
+	requests chan walRequest
+	done     chan struct{}
+	closed   chan struct{}
+
+	// écriture partielle impossible à retirer : plus aucun ajout n'est
+	// accepté (accédé par la seule boucle d'écriture)
+	failed error
+}
+
+type walRequest struct {
+	payload []byte
+	result  chan error
+}
+
+// Ouvre (ou crée) le journal ; window est l'attente maximale pour grouper les fsync
+func OpenWAL(path string, window time.Duration) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		path:     path,
+		file:     file,
+		window:   window,
+		requests: make(chan walRequest, walMaxBatch),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go w.writer()
+	return w, nil
+}
+
+// Écrit un enregistrement et attend qu'il soit durable
+func (w *WAL) Append(payload []byte) error {
+	req := walRequest{payload: payload, result: make(chan error, 1)}
+	select {
+	case w.requests <- req:
+	case <-w.done:
+		return errWALClosed
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-w.closed:
+		// déposé après la vidange finale : jamais écrit
+		select {
+		case err := <-req.result:
+			return err
+		default:
+			return errWALClosed
+		}
+	}
+}
+
+// Boucle d'écriture : regroupe les enregistrements en attente puis fait un seul fsync
+func (w *WAL) writer() {
+	defer close(w.closed)
+	for {
+		var batch []walRequest
+		select {
+		case req := <-w.requests:
+			batch = append(batch, req)
+		case <-w.done:
+			w.drain()
+			return
+		}
+
+		timer := time.NewTimer(w.window)
+	collect:
+		for len(batch) < walMaxBatch {
+			select {
+			case req := <-w.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			case <-w.done:
+				// le lot déjà accepté est écrit avant l'arrêt
+				break collect
+			}
+		}
+		timer.Stop()
+
+		err := w.writeBatch(batch)
+		for _, req := range batch {
+			req.result <- err
+		}
+	}
+}
+
+// Refuse les demandes encore en file à la fermeture
+func (w *WAL) drain() {
+	for {
+		select {
+		case req := <-w.requests:
+			req.result <- errWALClosed
+		default:
+			return
+		}
+	}
+}
+
+// Écrit un lot ; en cas d'échec, le fichier est ramené à sa taille d'avant
+// le lot pour que les lots suivants ne suivent pas des octets invalides
+// (la relecture s'arrêterait avant eux)
+func (w *WAL) writeBatch(batch []walRequest) error {
+	if w.failed != nil {
+		return w.failed
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := w.appendBatch(batch); err != nil {
+		if terr := w.file.Truncate(info.Size()); terr != nil {
+			w.failed = fmt.Errorf("journal inutilisable: %v (après: %v)", terr, err)
+			log.Printf("❌ Journal %s: %v", w.path, w.failed)
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *WAL) appendBatch(batch []walRequest) error {
+	buf := bufio.NewWriter(w.file)
+	header := make([]byte, walHeaderSize)
+	for _, req := range batch {
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(req.payload)))
+		binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(req.payload, walCRCTable))
+		buf.Write(header)
+		buf.Write(req.payload)
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Relit les enregistrements valides ; une fin tronquée ou corrompue est coupée
+// pour que les ajouts suivants repartent d'un état sain
+func (w *WAL) Replay(fn func(payload []byte) error) (int, error) {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(w.file)
+	header := make([]byte, walHeaderSize)
+	var offset int64
+	count := 0
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				log.Printf("⚠️  Journal %s: en-tête tronqué à l'offset %d", w.path, offset)
+			}
+			break
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if size > walMaxRecord {
+			log.Printf("⚠️  Journal %s: longueur invalide à l'offset %d", w.path, offset)
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			log.Printf("⚠️  Journal %s: enregistrement tronqué à l'offset %d", w.path, offset)
+			break
+		}
+		if crc32.Checksum(payload, walCRCTable) != sum {
+			log.Printf("⚠️  Journal %s: CRC invalide à l'offset %d", w.path, offset)
+			break
+		}
+		if err := fn(payload); err != nil {
+			return count, fmt.Errorf("rejeu offset %d: %v", offset, err)
+		}
+		offset += walHeaderSize + int64(size)
+		count++
+	}
+
+	if err := w.file.Truncate(offset); err != nil {
+		return count, err
+	}
+	_, err := w.file.Seek(0, io.SeekEnd)
+	return count, err
+}
+
+// Exécute fn en garantissant qu'aucun checkpoint ne tronque le journal entre
+// l'écriture de l'enregistrement et son application
+func (w *WAL) Ingest(payload []byte, apply func() error) error {
+	w.ingestMu.RLock()
+	defer w.ingestMu.RUnlock()
+	if err := w.Append(payload); err != nil {
+		return err
+	}
+	return apply()
+}
+
+// Synchronise l'état appliqué (sync) puis vide le journal
+func (w *WAL) Checkpoint(sync func() error) error {
+	w.ingestMu.Lock()
+	defer w.ingestMu.Unlock()
+	if err := sync(); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Lance les checkpoints périodiques
+func startCheckpointer(w *WAL, st Store, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.Checkpoint(st.Sync); err != nil {
+					log.Printf("❌ Erreur checkpoint journal: %v", err)
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// Arrête l'écriture et ferme le fichier
+func (w *WAL) Close() error {
+	close(w.done)
+	<-w.closed
+	return w.file.Close()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func replayAll(t *testing.T, w *WAL) []string {
+	t.Helper()
+	var records []string
+	if _, err := w.Replay(func(payload []byte) error {
+		records = append(records, string(payload))
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return records
+}
+
+func TestWALReplayDamagedTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   int // enregistrements relus
+	}{
+		{"intact", func(data []byte) []byte { return data }, 3},
+		{"en-tête tronqué", func(data []byte) []byte { return append(data, 1, 2, 3) }, 3},
+		{"enregistrement tronqué", func(data []byte) []byte { return data[:len(data)-2] }, 2},
+		{"CRC invalide", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}, 2},
+		{"longueur invalide", func(data []byte) []byte { return append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0) }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ingest.wal")
+			w, err := OpenWAL(path, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if err := w.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			w.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err = OpenWAL(path, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if got := replayAll(t, w); len(got) != tt.want {
+				t.Fatalf("rejoué %d enregistrement(s) %v, attendu %d", len(got), got, tt.want)
+			}
+
+			// la fin abîmée est coupée : un ajout suivant est relu
+			if err := w.Append([]byte("after")); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got := replayAll(t, w)
+			if len(got) != tt.want+1 || got[len(got)-1] != "after" {
+				t.Fatalf("après réparation: %v", got)
+			}
+		})
+	}
+}
+
+// Fermer le journal répond à toutes les demandes, même celles encore en file
+func TestWALCloseAnswersPending(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "ingest.wal"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 3 * walMaxBatch
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) { results <- w.Append([]byte(fmt.Sprintf("record-%d", i))) }(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	w.Close()
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-results:
+			if err != nil && err != errWALClosed {
+				t.Fatalf("Append: %v", err)
+			}
+		case <-timeout:
+			t.Fatalf("%d demande(s) sans réponse après Close", n-i)
+		}
+	}
+	if err := w.Append([]byte("late")); err != errWALClosed {
+		t.Errorf("Append après Close = %v, attendu %v", err, errWALClosed)
+	}
+}
+
+// Un enregistrement intact mais illisible est ignoré sans bloquer le rejeu
+func TestReplayWALSkipsUndecodable(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "ingest.wal"), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	good := testSnapshot("web", time.Now().Truncate(time.Second), 10)
+	payload, err := json.Marshal(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range [][]byte{[]byte("{pas du json"), payload} {
+		if err := w.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := NewMemoryStore(defaultRetention())
+	if err := replayWAL(w, st); err != nil {
+		t.Fatalf("replayWAL: %v", err)
+	}
+	if found, err := st.Contains(good); err != nil || !found {
+		t.Errorf("instantané valide non réappliqué: %v, %v", found, err)
+	}
+}